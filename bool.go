@@ -17,7 +17,7 @@ func (b bol) WithContext(*Context) Value {
 	return b
 }
 
-func (b bol) Int() uint64 {
+func (b bol) Int() int64 {
 	if b.value {
 		return 1
 	}
@@ -54,13 +54,7 @@ func (b bol) Bool() bool {
 }
 
 func (b bol) Compare(v Value) int {
-	if b.value == v.Bool() {
-		return 0
-	} else if b.value {
-		return 1
-	} else {
-		return -1
-	}
+	return compare(b, v)
 }
 
 func (b bol) Value() Value {
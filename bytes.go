@@ -2,7 +2,6 @@ package ngin
 
 import (
 	"bytes"
-	"strconv"
 )
 
 type bs struct {
@@ -17,16 +16,15 @@ func (bytes bs) WithContext(*Context) Value {
 	return bytes
 }
 
-func (bytes bs) Int() uint64 {
-	r, err := strconv.ParseUint(bytes.String(), 10, 64)
-	if err != nil {
-		panic(err)
-	}
-	return r
+// Int is 0 if the bytes aren't written as a number, see ToInt
+func (bytes bs) Int() int64 {
+	i, _, _, _ := parseNumber(string(bytes.content))
+	return i
 }
 
 func (bytes bs) Float() float64 {
-	return float64(bytes.Int())
+	_, f, _, _ := parseNumber(string(bytes.content))
+	return f
 }
 
 func (bytes bs) String() string {
@@ -42,7 +40,7 @@ func (bytes bs) Bytes() []byte {
 }
 
 func (bs bs) Compare(val Value) int {
-	return bytes.Compare(bs.content, val.Bytes())
+	return compare(bs, val)
 }
 
 func (bs bs) Slice() []Value {
@@ -0,0 +1,374 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type Severity int
+
+const (
+	Warning Severity = iota
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// Diagnostic is a problem found by Check
+type Diagnostic struct {
+	Pos      Pos
+	Severity Severity
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Pos.String(), d.Severity.String(), d.Message)
+}
+
+// Check looks for the mistakes which only show up when the script runs:
+// calls of functions neither bound on ctx nor defined by def, variables
+// read but never assigned, statements after return, invalid regexes,
+// comparisons which can't work and arithmetic on literals which aren't
+// numbers. The names the host binds when the script runs must be told with
+// Context.Provide.
+func Check(ctx *Context, stmts []Stmt) []Diagnostic {
+	c := checker{ctx: ctx, assigned: make(map[string]struct{}), declared: make(map[string]Pos), defined: make(map[string]struct{})}
+	c.collect(stmts)
+	c.stmts(stmts)
+	return c.diagnostics
+}
+
+type checker struct {
+	ctx         *Context
+	assigned    map[string]struct{}
+	declared    map[string]Pos
+	defined     map[string]struct{}
+	diagnostics []Diagnostic
+}
+
+func (c *checker) report(pos Pos, severity Severity, format string, args ...any) {
+	c.diagnostics = append(c.diagnostics, Diagnostic{Pos: pos, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// collect finds the names assigned or declared anywhere in the script
+func (c *checker) collect(stmts []Stmt) {
+	for _, s := range stmts {
+		switch s := s.(type) {
+		case AssignmentStmt:
+			c.assigned[rootName(s.Name)] = struct{}{}
+		case FuncStmt:
+			if s.Name != "var" {
+				continue
+			}
+			for _, arg := range s.Args {
+				if v, ok := arg.(*Variable); ok {
+					if _, ok := c.declared[v.Name]; !ok {
+						c.declared[v.Name] = s.Pos
+					}
+				}
+			}
+		case MatchThenStmt:
+			c.collect(s.Stmts)
+			if s.Else != nil {
+				c.collect([]Stmt{s.Else})
+			}
+		case IncludeStmt:
+			c.collect(s.Stmts)
+		case SwitchStmt:
+			for _, cs := range s.Cases {
+				c.collect(cs.Stmts)
+			}
+		case ForStmt:
+			c.assigned[s.Item] = struct{}{}
+			if s.Key != "" {
+				c.assigned[s.Key] = struct{}{}
+			}
+			c.collect(s.Stmts)
+		case DefStmt:
+			c.defined[s.Name] = struct{}{}
+			for _, param := range s.Params {
+				c.assigned[param] = struct{}{}
+			}
+			c.collect(s.Stmts)
+		}
+	}
+}
+
+func (c *checker) stmts(stmts []Stmt) {
+	// ended is the statement ending the block, if any
+	ended := ""
+	for _, s := range stmts {
+		if ended != "" {
+			c.report(stmtPos(s), Warning, "unreachable statement after %s", ended)
+			ended = ""
+		}
+		switch s := s.(type) {
+		case ReturnStmt:
+			ended = "return"
+			if s.Value != nil {
+				c.value(s.Pos, s.Value, true)
+			}
+		case BreakStmt:
+			ended = "break"
+		case ContinueStmt:
+			ended = "continue"
+		case ForStmt:
+			c.value(s.Pos, s.In, false)
+			c.stmts(s.Stmts)
+		case DefStmt:
+			c.stmts(s.Stmts)
+		case SwitchStmt:
+			c.switchStmt(s)
+		case AssignmentStmt:
+			c.value(s.Pos, s.Value, true)
+		case MatchThenStmt:
+			c.block(s)
+		case IncludeStmt:
+			c.stmts(s.Stmts)
+		default:
+			c.cond(s)
+		}
+	}
+}
+
+func (c *checker) block(mt MatchThenStmt) {
+	if mt.Match != nil {
+		c.cond(mt.Match)
+	}
+	c.stmts(mt.Stmts)
+	if e, ok := mt.Else.(MatchThenStmt); ok {
+		c.block(e)
+	}
+}
+
+func (c *checker) switchStmt(s SwitchStmt) {
+	c.value(s.Pos, s.Subject, false)
+	if v, ok := s.Subject.(*Variable); ok && len(v.Args) == 0 && !c.known(v.Name) {
+		c.report(s.Pos, Warning, "%s is never assigned, it's compared as the string %q", v.Name, v.Name)
+	}
+	for _, cs := range s.Cases {
+		if !cs.Default {
+			c.value(cs.Pos, cs.Value, false)
+		}
+		if cs.Operator == Like {
+			for _, item := range cs.Value.Slice() {
+				c.regex(cs.Pos, item)
+			}
+		}
+		c.stmts(cs.Stmts)
+	}
+}
+
+func (c *checker) cond(s Stmt) {
+	switch s := s.(type) {
+	case MatchStmt:
+		c.match(s)
+	case FuncStmt:
+		if c.ctx.GetFunc(s.Name) == nil && !c.isDefined(s.Name) {
+			c.report(s.Pos, Error, "function %s is not bound", s.Name)
+		}
+		if s.Name == "var" {
+			return
+		}
+		for _, arg := range s.Args {
+			c.value(s.Pos, arg, false)
+		}
+	case LogicStmt:
+		for _, operand := range s.Stmts {
+			c.cond(operand)
+		}
+	case ReturnStmt:
+		c.report(s.Pos, Error, "return can't be used as a condition")
+	}
+}
+
+func (c *checker) match(m MatchStmt) {
+	c.value(m.Pos, m.Left, false)
+	if v, ok := m.Left.(*Variable); ok && len(v.Args) == 0 && !c.known(v.Name) {
+		c.report(m.Pos, Warning, "%s is never assigned, it's compared as the string %q", v.Name, v.Name)
+	}
+	c.value(m.Pos, m.Right, false)
+	if _, ok := m.Left.(Slice); ok && m.Operator != EQ && m.Operator != NEQ {
+		c.report(m.Pos, Error, "a list on the left can only be compared with == or !=")
+	}
+	switch m.Operator {
+	case GT, GTE, LT, LTE:
+		switch m.Right.(type) {
+		case Slice:
+			c.report(m.Pos, Error, "%s can't compare with a list", operatorString[m.Operator])
+		case Null, bol:
+			c.report(m.Pos, Error, "%s can't compare with %s", operatorString[m.Operator], literal(m.Right))
+		}
+	case Like, NotLike:
+		for _, item := range m.Right.Slice() {
+			c.regex(m.Pos, item)
+		}
+	}
+}
+
+func (c *checker) regex(pos Pos, v Value) {
+	var pattern string
+	switch v := v.(type) {
+	case Null, bol:
+		c.report(pos, Error, "%s isn't a regex", literal(v))
+		return
+	case str, bs, it, flt:
+		pattern = v.String()
+	case *Variable:
+		if len(v.Args) > 0 || c.known(v.Name) {
+			return
+		}
+		pattern = v.Name
+	default:
+		return
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		c.report(pos, Error, "invalid regex %q: %s", pattern, err.Error())
+	}
+}
+
+// value checks the function calls in v, a name which is neither a variable
+// nor a function is taken as a string, which is reported when it's
+// assigned or interpolated since it's likely a mistake
+func (c *checker) value(pos Pos, v Value, assigned bool) {
+	switch v := v.(type) {
+	case *Variable:
+		if len(v.Args) > 0 {
+			if c.ctx.GetValuedFunc(v.Name) == nil && !c.isDefined(v.Name) && !c.provided(v.Name) {
+				c.report(pos, Error, "function %s is not bound", v.Name)
+			}
+			for _, arg := range v.Args {
+				c.value(pos, arg, false)
+			}
+			return
+		}
+		if !c.known(v.Name) && c.glued(pos, v.Name) {
+			return
+		}
+		if assigned && !c.known(v.Name) {
+			c.report(pos, Warning, "%s is neither a variable nor a function, it's used as the string %q", v.Name, v.Name)
+		}
+		root := rootName(v.Name)
+		if p, ok := c.declared[root]; ok && !c.isAssigned(root) && !c.provided(root) {
+			c.report(pos, Warning, "%s is declared at %s but never assigned", v.Name, p.String())
+		}
+	case Slice:
+		for _, item := range v {
+			c.value(pos, item, assigned)
+		}
+	case Template:
+		for _, part := range v.Parts {
+			if _, ok := part.(str); !ok {
+				c.value(pos, part, true)
+			}
+		}
+	case Expr:
+		c.value(pos, v.Left, assigned)
+		c.value(pos, v.Right, assigned)
+		c.arith(pos, v)
+	case str, bs:
+		c.glued(pos, v.String())
+	}
+}
+
+// glued reports s written like `count-1` or `total/2`, which is a single
+// name or string since '-' and '/' belong to names and paths unless a
+// whitespace or a ')' precedes them. Only the forms joining a number to a
+// variable or to another number are reported, `user-id` or `text/html`
+// aren't.
+func (c *checker) glued(pos Pos, s string) bool {
+	for _, op := range []string{"-", "/"} {
+		if strings.Count(s, op) != 1 {
+			continue
+		}
+		left, right, _ := strings.Cut(s, op)
+		_, _, _, lnum := parseNumber(left)
+		_, _, _, rnum := parseNumber(right)
+		if (lnum || c.isVariable(left)) && (rnum || c.isVariable(right)) && (lnum || rnum) {
+			c.report(pos, Warning, "%s is read as a single word, write %s %s %s to compute it", s, left, op, right)
+			return true
+		}
+	}
+	return false
+}
+
+// isVariable reports whether s is a name known as a variable
+func (c *checker) isVariable(s string) bool {
+	return nameRegex.MatchString(s) && c.known(s)
+}
+
+// arith reports the literal operands which make an expression null
+func (c *checker) arith(pos Pos, e Expr) {
+	if e.Operator == Add {
+		return
+	}
+	for _, operand := range []Value{e.Left, e.Right} {
+		switch operand.(type) {
+		case str, bs, bol, Null:
+			if _, _, _, ok := number(operand); !ok {
+				c.report(pos, Error, "%s needs numbers, got %s", e.Operator.String(), literal(operand))
+			}
+		}
+	}
+	if e.Operator != Div && e.Operator != Mod {
+		return
+	}
+	if _, f, _, ok := number(e.Right); ok && f == 0 {
+		c.report(pos, Error, "division by zero")
+	}
+}
+
+// known reports whether name is assigned in the script, bound on the
+// context or provided by the host
+func (c *checker) known(name string) bool {
+	root := rootName(name)
+	if c.isAssigned(root) {
+		return true
+	}
+	if _, ok := c.declared[root]; ok {
+		return true
+	}
+	return c.ctx.GetValuedFunc(name) != nil || c.ctx.IsVar(name) || c.isDefined(name) || c.provided(name)
+}
+
+func (c *checker) isDefined(name string) bool {
+	_, ok := c.defined[name]
+	return ok
+}
+
+func (c *checker) isAssigned(name string) bool {
+	_, ok := c.assigned[name]
+	return ok
+}
+
+func (c *checker) provided(name string) bool {
+	return c.ctx.IsProvided(rootName(name))
+}
+
+// literal returns v as written in the script
+func literal(v Value) string {
+	if _, ok := v.(Null); ok {
+		return "null"
+	}
+	return v.String()
+}
+
+var nameRegex = regexp.MustCompile(`^[A-Za-z_][\w.-]*$`)
+
+func rootName(name string) string {
+	if idx := strings.Index(name, "."); idx > -1 {
+		return name[:idx]
+	}
+	return name
+}
@@ -0,0 +1,197 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dev-mockingbird/ngin"
+)
+
+func TestCheck(t *testing.T) {
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: strings.NewReader(`
+var token unused;
+header.request-id == null {
+    header.request-id = uuid;
+}
+listen 6000 {
+    header.x == "${tokn}" {
+        forward;
+        return;
+        log done;
+    }
+    path ~ ^/api | null {
+        response.body = encode-json token;
+        response.code = unused;
+    }
+    response.code > 200 | 300;
+    methd == GET;
+    lower path;
+    token = now;
+}
+`), File: "test.ngin"}
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := ngin.NewContext()
+	ctx.Provide("header", "path", "response")
+	ctx.BindFunc("listen", func(*ngin.Context, ...ngin.Value) (bool, error) { return true, nil })
+	ctx.BindFunc("forward", func(*ngin.Context, ...ngin.Value) (bool, error) { return true, nil })
+	ctx.BindFunc("log", func(*ngin.Context, ...ngin.Value) (bool, error) { return true, nil })
+	ctx.BindValuedFunc("encode-json", func(*ngin.Context, ...ngin.Value) ngin.Value { return ngin.Null{} })
+	expect := []string{
+		`test.ngin:4:5: warning: uuid is neither a variable nor a function, it's used as the string "uuid"`,
+		`test.ngin:7:5: warning: tokn is neither a variable nor a function, it's used as the string "tokn"`,
+		`test.ngin:10:9: warning: unreachable statement after return`,
+		`test.ngin:12:5: error: null isn't a regex`,
+		`test.ngin:14:9: warning: unused is declared at test.ngin:2:1 but never assigned`,
+		`test.ngin:16:5: error: > can't compare with a list`,
+		`test.ngin:17:5: warning: methd is never assigned, it's compared as the string "methd"`,
+		`test.ngin:18:5: error: function lower is not bound`,
+		`test.ngin:19:5: warning: now is neither a variable nor a function, it's used as the string "now"`,
+	}
+	diagnostics := ngin.Check(ctx, stmts)
+	if len(diagnostics) != len(expect) {
+		t.Fatalf("expect %d diagnostics, got %v", len(expect), diagnostics)
+	}
+	for i, d := range diagnostics {
+		if d.String() != expect[i] {
+			t.Fatalf("expect %s, got %s", expect[i], d.String())
+		}
+	}
+}
+
+func TestCheck_Def(t *testing.T) {
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: strings.NewReader(`
+def auth token {
+    token == null {
+        return false;
+    }
+    return "${token}";
+    done;
+}
+user = auth header;
+auth header;
+user = authorize header;
+`), File: "def.ngin"}
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := ngin.NewContext()
+	ctx.Provide("header")
+	expect := []string{
+		`def.ngin:7:5: warning: unreachable statement after return`,
+		`def.ngin:7:5: error: function done is not bound`,
+		`def.ngin:11:1: error: function authorize is not bound`,
+	}
+	diagnostics := ngin.Check(ctx, stmts)
+	if len(diagnostics) != len(expect) {
+		t.Fatalf("expect %d diagnostics, got %v", len(expect), diagnostics)
+	}
+	for i, d := range diagnostics {
+		if d.String() != expect[i] {
+			t.Fatalf("expect %s, got %s", expect[i], d.String())
+		}
+	}
+}
+
+func TestCheck_Switch(t *testing.T) {
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: strings.NewReader(`
+switch methd {
+    case GET {
+        result = ok;
+    }
+    case ~ null {
+        result = "no";
+    }
+}
+`), File: "switch.ngin"}
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{
+		`switch.ngin:2:1: warning: methd is never assigned, it's compared as the string "methd"`,
+		`switch.ngin:4:9: warning: ok is neither a variable nor a function, it's used as the string "ok"`,
+		`switch.ngin:6:5: error: null isn't a regex`,
+	}
+	diagnostics := ngin.Check(ngin.NewContext(), stmts)
+	if len(diagnostics) != len(expect) {
+		t.Fatalf("expect %d diagnostics, got %v", len(expect), diagnostics)
+	}
+	for i, d := range diagnostics {
+		if d.String() != expect[i] {
+			t.Fatalf("expect %s, got %s", expect[i], d.String())
+		}
+	}
+}
+
+func TestCheck_For(t *testing.T) {
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: strings.NewReader(`
+for k, v in query {
+    last = "${k}=${v}";
+    break;
+    last = done;
+}
+`), File: "for.ngin"}
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := ngin.NewContext()
+	ctx.Provide("query")
+	expect := []string{
+		`for.ngin:5:5: warning: unreachable statement after break`,
+		`for.ngin:5:5: warning: done is neither a variable nor a function, it's used as the string "done"`,
+	}
+	diagnostics := ngin.Check(ctx, stmts)
+	if len(diagnostics) != len(expect) {
+		t.Fatalf("expect %d diagnostics, got %v", len(expect), diagnostics)
+	}
+	for i, d := range diagnostics {
+		if d.String() != expect[i] {
+			t.Fatalf("expect %s, got %s", expect[i], d.String())
+		}
+	}
+}
+
+func TestCheck_Arith(t *testing.T) {
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: strings.NewReader(`
+var count;
+count = count + 1;
+label = "n=" + count;
+count = count * abc;
+count = count % 0;
+count / 2.5 > 1 { }
+count = count - "n/a";
+count = count-1;
+count = 10/count;
+label = text/html;
+`), File: "arith.ngin"}
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{
+		`arith.ngin:5:1: warning: abc is neither a variable nor a function, it's used as the string "abc"`,
+		`arith.ngin:6:1: error: division by zero`,
+		`arith.ngin:8:1: error: - needs numbers, got n/a`,
+		`arith.ngin:9:1: warning: count-1 is read as a single word, write count - 1 to compute it`,
+		`arith.ngin:10:1: warning: 10/count is read as a single word, write 10 / count to compute it`,
+	}
+	diagnostics := ngin.Check(ngin.NewContext(), stmts)
+	if len(diagnostics) != len(expect) {
+		t.Fatalf("expect %d diagnostics, got %v", len(expect), diagnostics)
+	}
+	for i, d := range diagnostics {
+		if d.String() != expect[i] {
+			t.Fatalf("expect %s, got %s", expect[i], d.String())
+		}
+	}
+}
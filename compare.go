@@ -0,0 +1,167 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// compare is the comparison of the values, every Compare is compare with
+// the value as the left operand. It returns -1, 0 or 1 when left is less
+// than, equal to or greater than right, following the first row matching
+// the operands:
+//
+//	left, right            compared as
+//	null, null             equal
+//	null, any              null is less than anything else
+//	list, any              lists, the longer is greater, the ones of the
+//	                       same length item by item; a value which isn't
+//	                       a list is a list of one item
+//	bool, any              bools, false is less than true, the other value
+//	                       is converted by Bool
+//	time, time             times, the earlier is less
+//	duration, duration     durations
+//	number, number         numbers, exactly if both are ints, otherwise
+//	                       as floats
+//	any, any               strings, byte by byte
+//
+// Numbers are ints, floats and strings or bytes written as decimal
+// numbers, like "42", "-7" or "1.5e3", so the query parameter page="10" is
+// greater than 9 while "10" is less than "9" as strings. A time is the
+// number of seconds since the unix epoch and a duration its number of
+// seconds. The value of a failed operation compares like null.
+func compare(left, right Value) int {
+	left, right = left.Value(), right.Value()
+	ln, rn := empty(left), empty(right)
+	switch {
+	case ln && rn:
+		return 0
+	case ln:
+		return -1
+	case rn:
+		return 1
+	}
+	_, ls := left.(Slice)
+	_, rs := right.(Slice)
+	if ls || rs {
+		return compareSlices(left.Slice(), right.Slice())
+	}
+	_, lb := left.(bol)
+	_, rb := right.(bol)
+	if lb || rb {
+		return compareBools(left.Bool(), right.Bool())
+	}
+	if lt, ok := left.(tm); ok {
+		if rt, ok := right.(tm); ok {
+			return compareInts(lt.value.Sub(rt.value).Nanoseconds(), 0)
+		}
+	}
+	if ld, ok := left.(dur); ok {
+		if rd, ok := right.(dur); ok {
+			return compareInts(int64(ld.value), int64(rd.value))
+		}
+	}
+	li, lf, lint, lok := number(left)
+	ri, rf, rint, rok := number(right)
+	switch {
+	case lok && rok && lint && rint:
+		return compareInts(li, ri)
+	case lok && rok:
+		return compareFloats(lf, rf)
+	}
+	return bytes.Compare(left.Bytes(), right.Bytes())
+}
+
+// empty reports whether v is null or the value of a failed operation
+func empty(v Value) bool {
+	switch v.(type) {
+	case Null, failure:
+		return true
+	}
+	return false
+}
+
+func compareSlices(l, r []Value) int {
+	if len(l) != len(r) {
+		return compareInts(int64(len(l)), int64(len(r)))
+	}
+	for i := range l {
+		if ret := compare(l[i], r[i]); ret != 0 {
+			return ret
+		}
+	}
+	return 0
+}
+
+func compareBools(l, r bool) int {
+	switch {
+	case l == r:
+		return 0
+	case l:
+		return 1
+	}
+	return -1
+}
+
+func compareInts(l, r int64) int {
+	switch {
+	case l > r:
+		return 1
+	case l < r:
+		return -1
+	}
+	return 0
+}
+
+func compareFloats(l, r float64) int {
+	switch {
+	case l > r:
+		return 1
+	case l < r:
+		return -1
+	}
+	return 0
+}
+
+// number returns v as a number, isInt tells whether it's an int, ok is
+// false if v isn't a number
+func number(v Value) (i int64, f float64, isInt bool, ok bool) {
+	switch v := v.(type) {
+	case it:
+		return v.value, float64(v.value), true, true
+	case flt:
+		return 0, v.value, false, true
+	case dur, tm:
+		return v.Int(), v.Float(), false, true
+	case str:
+		return parseNumber(v.content)
+	case bs:
+		return parseNumber(string(v.content))
+	}
+	return 0, 0, false, false
+}
+
+// parseNumber parses s written as a decimal int or float
+func parseNumber(s string) (i int64, f float64, isInt bool, ok bool) {
+	if s == "" || strings.IndexFunc(s, notNumeric) > -1 {
+		return 0, 0, false, false
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, float64(i), true, true
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return int64(f), f, false, true
+	}
+	return 0, 0, false, false
+}
+
+// notNumeric reports whether r can't be in a decimal number, it keeps
+// words like "inf" or "nan" from being taken as numbers
+func notNumeric(r rune) bool {
+	return (r < '0' || r > '9') && r != '.' && r != '-' && r != '+' && r != 'e' && r != 'E'
+}
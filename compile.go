@@ -0,0 +1,719 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Program is a compiled script. It's never changed once compiled, so it
+// can be run by many requests at the same time, each with its own context.
+type Program struct {
+	steps []step
+}
+
+// Block is a compiled block, its statements run in a frame of their own
+// where the names declared by var in the block have slots
+type Block struct {
+	scope *scope
+	steps []step
+}
+
+type step func(ctx *Context) (bool, error)
+
+// scope is what the compiler knows about the frames of a block: the names
+// declared by var in the block, each one has a slot
+type scope struct {
+	names  []string
+	index  map[string]int
+	parent *scope
+}
+
+// Compile compiles the statements to a tree of closures. The names declared
+// by var in an enclosing block are resolved to the slots of the block, the
+// other names are looked up by name when the script runs, as the host may
+// bind any name. A variable declared by var hides the valued functions
+// bound later outside of its block with the same name.
+func Compile(stmts []Stmt) *Program {
+	c := compiler{defs: make(map[string]struct{})}
+	c.collect(stmts)
+	return &Program{steps: c.stmts(stmts)}
+}
+
+// Run runs the statements in ctx, it stops at a return or an error
+func (p *Program) Run(ctx *Context) (bool, error) {
+	return run(ctx, p.steps)
+}
+
+// Run runs the block in a new frame of ctx
+func (b *Block) Run(ctx *Context) (bool, error) {
+	return run(ctx.enter(b.scope), b.steps)
+}
+
+func run(ctx *Context, steps []step) (bool, error) {
+	for _, s := range steps {
+		if con, err := s(ctx); !con || err != nil {
+			return con, err
+		}
+	}
+	return true, nil
+}
+
+type compiler struct {
+	// scope is the scope of the block being compiled, it's nil for the
+	// statements running in the frame given by the host
+	scope *scope
+	// defs are the names of the functions defined by def in the script
+	defs map[string]struct{}
+}
+
+// stmts compiles the statements of a block. The functions defined by def
+// in the block, the included files' included, are bound before the other
+// statements run, so they can be called before their def.
+func (c *compiler) stmts(stmts []Stmt) []step {
+	return c.sequence(stmts, c.hoist(stmts, nil))
+}
+
+// hoist appends the steps binding the functions defined in stmts
+func (c *compiler) hoist(stmts []Stmt, steps []step) []step {
+	for _, s := range stmts {
+		switch s := s.(type) {
+		case DefStmt:
+			steps = append(steps, c.def(s))
+		case IncludeStmt:
+			steps = c.hoist(s.Stmts, steps)
+		}
+	}
+	return steps
+}
+
+// sequence appends the steps of stmts but the defs, which are hoisted
+func (c *compiler) sequence(stmts []Stmt, steps []step) []step {
+	for _, s := range stmts {
+		switch s.(type) {
+		case EmptyStmt, DefStmt:
+			continue
+		}
+		steps = append(steps, c.stmt(s))
+	}
+	return steps
+}
+
+// collect finds the functions defined by def anywhere in stmts
+func (c *compiler) collect(stmts []Stmt) {
+	for _, s := range stmts {
+		switch s := s.(type) {
+		case DefStmt:
+			c.defs[s.Name] = struct{}{}
+			c.collect(s.Stmts)
+		case MatchThenStmt:
+			c.collect(s.Stmts)
+			if s.Else != nil {
+				c.collect([]Stmt{s.Else})
+			}
+		case IncludeStmt:
+			c.collect(s.Stmts)
+		case SwitchStmt:
+			for _, cs := range s.Cases {
+				c.collect(cs.Stmts)
+			}
+		case ForStmt:
+			c.collect(s.Stmts)
+		}
+	}
+}
+
+func (c *compiler) block(stmts []Stmt) *Block {
+	sc := &scope{index: make(map[string]int), parent: c.scope}
+	sc.declare(stmts)
+	inner := compiler{scope: sc, defs: c.defs}
+	return &Block{scope: sc, steps: inner.stmts(stmts)}
+}
+
+// declare gives a slot to each name declared by var in stmts
+func (sc *scope) declare(stmts []Stmt) {
+	for _, s := range stmts {
+		switch s := s.(type) {
+		case IncludeStmt:
+			sc.declare(s.Stmts)
+		case FuncStmt:
+			if s.Name != "var" {
+				continue
+			}
+			for _, arg := range s.Args {
+				v, ok := arg.(*Variable)
+				if !ok {
+					continue
+				}
+				if _, ok := sc.index[v.Name]; ok || len(v.Args) > 0 {
+					continue
+				}
+				if root, _ := splitName(v.Name); root != v.Name {
+					continue
+				}
+				sc.index[v.Name] = len(sc.names)
+				sc.names = append(sc.names, v.Name)
+			}
+		}
+	}
+}
+
+func (c *compiler) stmt(s Stmt) step {
+	switch s := s.(type) {
+	case ReturnStmt:
+		if s.Value == nil {
+			return func(*Context) (bool, error) {
+				return false, nil
+			}
+		}
+		value := c.eval(s.Value)
+		return func(ctx *Context) (bool, error) {
+			ctx.pos = s.Pos
+			v := value(ctx)
+			if err := Err(v); err != nil {
+				return false, s.Pos.wrap(err)
+			}
+			if result := ctx.result(); result != nil {
+				*result = v
+			}
+			return false, nil
+		}
+	case DefStmt:
+		return c.def(s)
+	case AssignmentStmt:
+		ref := c.ref(s.Name)
+		value := c.eval(s.Value)
+		return func(ctx *Context) (bool, error) {
+			ctx.pos = s.Pos
+			v := value(ctx)
+			if err := Err(v); err != nil {
+				return false, s.Pos.wrap(err)
+			}
+			// the attributes of the variable may be assigned later, the
+			// complex value it's assigned from isn't changed by them
+			if c, ok := v.(*Complex); ok {
+				v = c.clone()
+			}
+			ref.bind(ctx, v)
+			return true, nil
+		}
+	case FuncStmt:
+		return c.call(s, false)
+	case MatchStmt:
+		left, right := c.eval(s.Left), c.eval(s.Right)
+		return func(ctx *Context) (bool, error) {
+			ctx.pos = s.Pos
+			l, r := left(ctx), right(ctx)
+			if err := Err(l); err != nil {
+				return false, s.Pos.wrap(err)
+			}
+			if err := Err(r); err != nil {
+				return false, s.Pos.wrap(err)
+			}
+			ok, err := s.compare(l, r)
+			return ok, s.Pos.wrap(err)
+		}
+	case LogicStmt:
+		return c.logic(s)
+	case IncludeStmt:
+		steps := c.sequence(s.Stmts, nil)
+		return func(ctx *Context) (bool, error) {
+			return run(ctx, steps)
+		}
+	case EmptyStmt:
+		return func(*Context) (bool, error) {
+			return true, nil
+		}
+	case MatchThenStmt:
+		return c.matchThen(s)
+	case SwitchStmt:
+		return c.switchStmt(s)
+	case ForStmt:
+		return c.forStmt(s)
+	case BreakStmt:
+		return func(*Context) (bool, error) {
+			return false, errBreak
+		}
+	case ContinueStmt:
+		return func(*Context) (bool, error) {
+			return false, errContinue
+		}
+	}
+	return s.Execute
+}
+
+// call compiles the call of a function. The function tells whether the
+// block goes on, but a function defined by def only tells whether the
+// condition holds when it's a condition, its result is ignored otherwise.
+func (c *compiler) call(s FuncStmt, cond bool) step {
+	args := make([]func(*Context) Value, len(s.Args))
+	for i, arg := range s.Args {
+		args[i] = c.bind(arg)
+	}
+	_, defined := c.defs[s.Name]
+	ignored := defined && !cond
+	return func(ctx *Context) (bool, error) {
+		ctx.pos = s.Pos
+		funk := ctx.GetFunc(s.Name)
+		if funk == nil {
+			return false, s.Pos.wrap(fmt.Errorf("func [%s] not found", s.Name))
+		}
+		bound := make([]Value, len(args))
+		for i, arg := range args {
+			bound[i] = arg(ctx)
+		}
+		ok, err := funk(ctx, bound...)
+		if err != nil {
+			return false, s.Pos.wrap(err)
+		}
+		return ok || ignored, nil
+	}
+}
+
+// def compiles the step binding the function defined by d
+func (c *compiler) def(d DefStmt) step {
+	f := c.function(d)
+	return func(ctx *Context) (bool, error) {
+		ctx.BindFunc(d.Name, f.stmt)
+		ctx.BindValuedFunc(d.Name, f.value)
+		return true, nil
+	}
+}
+
+// cond compiles the condition s
+func (c *compiler) cond(s Stmt) step {
+	if f, ok := s.(FuncStmt); ok {
+		return c.call(f, true)
+	}
+	return c.stmt(s)
+}
+
+func (c *compiler) logic(l LogicStmt) step {
+	operands := make([]step, len(l.Stmts))
+	for i, s := range l.Stmts {
+		operands[i] = c.cond(s)
+	}
+	switch l.Operator {
+	case And:
+		return func(ctx *Context) (bool, error) {
+			for _, s := range operands {
+				if ok, err := s(ctx); err != nil || !ok {
+					return false, err
+				}
+			}
+			return true, nil
+		}
+	case Or:
+		return func(ctx *Context) (bool, error) {
+			for _, s := range operands {
+				if ok, err := s(ctx); err != nil || ok {
+					return ok, err
+				}
+			}
+			return false, nil
+		}
+	case Not:
+		if len(operands) != 1 {
+			err := l.Pos.wrap(errors.New("'!' requires exactly one condition"))
+			return func(*Context) (bool, error) {
+				return false, err
+			}
+		}
+		return func(ctx *Context) (bool, error) {
+			ok, err := operands[0](ctx)
+			return !ok, err
+		}
+	}
+	err := l.Pos.wrap(errors.New("not supported logic operator"))
+	return func(*Context) (bool, error) {
+		return false, err
+	}
+}
+
+func (c *compiler) matchThen(mt MatchThenStmt) step {
+	match := c.cond(mt.Match)
+	body := c.block(mt.Stmts)
+	var els step
+	if mt.Else != nil {
+		els = c.stmt(mt.Else)
+	}
+	return func(ctx *Context) (bool, error) {
+		block := ctx.block
+		ctx.block = body
+		matched, err := match(ctx)
+		ctx.block = block
+		if err != nil {
+			return true, err
+		}
+		if !matched {
+			if els != nil {
+				return els(ctx)
+			}
+			return true, nil
+		}
+		return body.Run(ctx)
+	}
+}
+
+// MaxCallDepth is how deep the functions defined by def can call each
+// other, a deeper call fails
+const MaxCallDepth = 100
+
+// function is a function defined by def
+type function struct {
+	name   string
+	params int
+	body   *Block
+	stmt   Func
+	value  ValuedFunc
+}
+
+// function compiles the body of d, the parameters are the first slots of
+// its frame. The frame is derived from the caller's, so the names not
+// declared in the body are looked up from the caller.
+func (c *compiler) function(d DefStmt) *function {
+	sc := &scope{index: make(map[string]int)}
+	for _, param := range d.Params {
+		sc.index[param] = len(sc.names)
+		sc.names = append(sc.names, param)
+	}
+	sc.declare(d.Stmts)
+	inner := compiler{scope: sc, defs: c.defs}
+	f := &function{name: d.Name, params: len(d.Params), body: &Block{scope: sc, steps: inner.stmts(d.Stmts)}}
+	f.stmt = func(ctx *Context, args ...Value) (bool, error) {
+		ret, err := f.call(ctx, args)
+		if b, ok := ret.(bol); ok {
+			return b.Bool(), err
+		}
+		return true, err
+	}
+	f.value = func(ctx *Context, args ...Value) Value {
+		ret, err := f.call(ctx, args)
+		if err != nil {
+			return Fail(err)
+		}
+		if ret == nil {
+			return Null{}
+		}
+		return ret
+	}
+	return f
+}
+
+// call runs the function in a frame of ctx, it returns the value returned
+// by the function, nil if it returns none. The missing arguments are null.
+func (f *function) call(ctx *Context, args []Value) (Value, error) {
+	if len(args) > f.params {
+		return nil, fmt.Errorf("%s takes %d arguments, got %d", f.name, f.params, len(args))
+	}
+	depth := 0
+	for c := ctx; c != nil; c = c.parent {
+		if c.ret != nil {
+			depth++
+		}
+	}
+	if depth >= MaxCallDepth {
+		return nil, fmt.Errorf("%s: call depth exceeds %d", f.name, MaxCallDepth)
+	}
+	var ret Value
+	frame := ctx.enter(f.body.scope)
+	frame.ret = &ret
+	for i, arg := range args {
+		v := arg.Value()
+		if err := Err(v); err != nil {
+			return nil, err
+		}
+		if c, ok := v.(*Complex); ok {
+			v = c.clone()
+		}
+		frame.slots[i] = slot{value: v, declared: true}
+	}
+	for i := len(args); i < f.params; i++ {
+		frame.slots[i] = slot{value: Null{}, declared: true}
+	}
+	_, err := run(frame, f.body.steps)
+	return ret, err
+}
+
+// MaxIterations is how many items a for loop can iterate, a loop over
+// more items fails before running any iteration
+const MaxIterations = 10000
+
+// errBreak and errContinue are returned by break and continue, up to the
+// for loop. The parser makes sure they're in a loop.
+var (
+	errBreak    = errors.New("break")
+	errContinue = errors.New("continue")
+)
+
+func (c *compiler) forStmt(s ForStmt) step {
+	in := c.eval(s.In)
+	sc := &scope{index: make(map[string]int), parent: c.scope}
+	for _, name := range []string{s.Key, s.Item} {
+		if name != "" {
+			sc.index[name] = len(sc.names)
+			sc.names = append(sc.names, name)
+		}
+	}
+	sc.declare(s.Stmts)
+	inner := compiler{scope: sc, defs: c.defs}
+	steps := inner.stmts(s.Stmts)
+	return func(ctx *Context) (bool, error) {
+		ctx.pos = s.Pos
+		v := in(ctx)
+		if err := Err(v); err != nil {
+			return false, s.Pos.wrap(err)
+		}
+		keys, items := iterate(v)
+		if len(items) > MaxIterations {
+			return false, s.Pos.wrap(fmt.Errorf("for: %d items exceed the limit of %d iterations", len(items), MaxIterations))
+		}
+		for i, item := range items {
+			frame := ctx.enter(sc)
+			if s.Key != "" {
+				frame.slots[0] = slot{value: keys[i], declared: true}
+				frame.slots[1] = slot{value: item, declared: true}
+			} else {
+				frame.slots[0] = slot{value: item, declared: true}
+			}
+			con, err := run(frame, steps)
+			switch {
+			case errors.Is(err, errBreak):
+				return true, nil
+			case errors.Is(err, errContinue):
+				continue
+			case err != nil || !con:
+				return con, err
+			}
+		}
+		return true, nil
+	}
+}
+
+// iterate returns the indexes and the items of a list, or the names and
+// the values of the attributes of a complex value
+func iterate(v Value) (keys, items []Value) {
+	switch v := v.(type) {
+	case *Complex:
+		for _, name := range v.names {
+			keys = append(keys, String(name))
+			items = append(items, v.attributes[name])
+		}
+		return keys, items
+	case Null:
+		return nil, nil
+	}
+	items = v.Slice()
+	keys = make([]Value, len(items))
+	for i := range items {
+		keys[i] = Int(int64(i))
+	}
+	return keys, items
+}
+
+type caseStep struct {
+	match MatchStmt
+	value func(*Context) Value
+	body  *Block
+}
+
+func (c *compiler) switchStmt(s SwitchStmt) step {
+	subject := c.eval(s.Subject)
+	cases := make([]caseStep, 0, len(s.Cases))
+	var def *Block
+	for _, cs := range s.Cases {
+		body := c.block(cs.Stmts)
+		if cs.Default {
+			def = body
+			continue
+		}
+		match := MatchStmt{Pos: cs.Pos, Operator: cs.Operator, Right: cs.Value, Regexes: cs.Regexes}
+		cases = append(cases, caseStep{match: match, value: c.eval(cs.Value), body: body})
+	}
+	return func(ctx *Context) (bool, error) {
+		ctx.pos = s.Pos
+		v := subject(ctx)
+		if err := Err(v); err != nil {
+			return false, s.Pos.wrap(err)
+		}
+		for _, cs := range cases {
+			right := cs.value(ctx)
+			if err := Err(right); err != nil {
+				return false, cs.match.Pos.wrap(err)
+			}
+			matched, err := cs.match.compare(v, right)
+			if err != nil {
+				return true, cs.match.Pos.wrap(err)
+			}
+			if matched {
+				return cs.body.Run(ctx)
+			}
+		}
+		if def != nil {
+			return def.Run(ctx)
+		}
+		return true, nil
+	}
+}
+
+func (c *compiler) values(values []Value) []Value {
+	ret := make([]Value, len(values))
+	for i, v := range values {
+		ret[i] = c.value(v)
+	}
+	return ret
+}
+
+// value resolves the variables in v
+func (c *compiler) value(v Value) Value {
+	switch v := v.(type) {
+	case *Variable:
+		return &Variable{Name: v.Name, Args: c.values(v.Args), ref: c.ref(v.Name)}
+	case Slice:
+		return Slice(c.values(v))
+	case Template:
+		return Template{Parts: c.values(v.Parts)}
+	case Expr:
+		return Expr{Operator: v.Operator, Left: c.value(v.Left), Right: c.value(v.Right)}
+	}
+	return v
+}
+
+// bind returns a function binding v to the context, the constants are
+// bound once
+func (c *compiler) bind(v Value) func(*Context) Value {
+	if isConst(v) {
+		return func(*Context) Value {
+			return v
+		}
+	}
+	v = c.value(v)
+	return v.WithContext
+}
+
+// eval returns a function evaluating v in the context
+func (c *compiler) eval(v Value) func(*Context) Value {
+	if isConst(v) {
+		v = v.Value()
+		return func(*Context) Value {
+			return v
+		}
+	}
+	if v, ok := c.value(v).(*Variable); ok {
+		return func(ctx *Context) Value {
+			return v.ref.value(ctx, v.Args)
+		}
+	}
+	v = c.value(v)
+	return func(ctx *Context) Value {
+		return v.WithContext(ctx).Value()
+	}
+}
+
+// isConst reports whether v is the same whatever the context is
+func isConst(v Value) bool {
+	switch v := v.(type) {
+	case *Variable, Template:
+		return false
+	case Slice:
+		for _, item := range v {
+			if !isConst(item) {
+				return false
+			}
+		}
+	case Expr:
+		return isConst(v.Left) && isConst(v.Right)
+	}
+	return true
+}
+
+// ref resolves name to the slot of the nearest enclosing block declaring
+// it with var
+func (c *compiler) ref(name string) *varRef {
+	r := &varRef{name: name}
+	r.root, r.rest = splitName(name)
+	scopes := []*scope{}
+	for sc := c.scope; sc != nil; sc = sc.parent {
+		scopes = append(scopes, sc)
+		if i, ok := sc.index[r.root]; ok {
+			r.scopes, r.slot = scopes, i
+			break
+		}
+	}
+	return r
+}
+
+// varRef is a name resolved by the compiler
+type varRef struct {
+	name, root, rest string
+	// scopes are the scopes of the frames from the one running the
+	// statement to the one having the slot, it's empty if the name has
+	// no slot
+	scopes []*scope
+	slot   int
+}
+
+// frame returns the frame having the slot of the name, it's nil if the
+// name must be looked up by name: when the frames aren't the ones
+// compiled for, e.g. a block run by the host in a frame of its own, when
+// a frame between declares the name too, or when the var statement
+// hasn't declared it, e.g. because it was declared by the host already,
+// or when the frame is shared.
+func (r *varRef) frame(ctx *Context) *Context {
+	if len(r.scopes) == 0 {
+		return nil
+	}
+	f := ctx
+	last := len(r.scopes) - 1
+	for i, sc := range r.scopes {
+		if f == nil || f.scope != sc {
+			return nil
+		}
+		if i == last {
+			break
+		}
+		if _, ok := f.vars[r.root]; ok {
+			return nil
+		}
+		f = f.parent
+	}
+	if f.shared() || !f.slots[r.slot].declared {
+		return nil
+	}
+	return f
+}
+
+func (r *varRef) bind(ctx *Context, val Value) {
+	if f := r.frame(ctx); f != nil {
+		f.setSlot(r.slot, r.rest, val)
+		return
+	}
+	ctx.BindValue(r.name, val)
+}
+
+// value returns the value of a variable, like Variable.Value
+func (r *varRef) value(ctx *Context, args []Value) Value {
+	f := r.frame(ctx)
+	if f == nil {
+		return ctx.value(r.name, args)
+	}
+	if funk, ok := f.valuedFunks[r.name]; ok {
+		return funk(ctx, bindArgs(ctx, args)...)
+	}
+	if v := f.slotValue(r.slot, r.rest); !isNull(v) {
+		return v
+	}
+	if f.parent != nil {
+		return f.parent.GetValue(r.name)
+	}
+	return Null{}
+}
+
+func isNull(v Value) bool {
+	_, ok := v.(Null)
+	return ok || v == nil
+}
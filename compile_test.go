@@ -0,0 +1,478 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dev-mockingbird/ngin"
+)
+
+const program = `var user-id;
+header.request-id == null {
+    header.request-id = "generated";
+}
+response.header.request-id = header.request-id;
+host == 127.0.0.1:6000 | hello.com | world.com {
+    path != /api/v1/signin | /api/v1/signup {
+        header.Authorization ~ ` + "`^Bearer .+`" + ` {
+            var token;
+            token = header.Authorization;
+            user-id = "u-${token}";
+            header.user-id = user-id;
+        } else {
+            response.code = 401;
+            return;
+        }
+    }
+    method == GET && query.page >= 1 {
+        response.code = 200;
+    }
+}
+`
+
+func compile(t testing.TB, src string) *ngin.Program {
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: strings.NewReader(src)}
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ngin.Compile(stmts)
+}
+
+func request(root *ngin.Context, auth string) *ngin.Context {
+	ctx := root.Folk()
+	ctx.Declare("path", "host", "method", "header", "response", "query")
+	if auth != "" {
+		ctx.BindValue("header.Authorization", ngin.String(auth))
+	}
+	ctx.BindValue("host", ngin.String("hello.com"))
+	ctx.BindValue("path", ngin.String("/api/v1/users"))
+	ctx.BindValue("method", ngin.String("GET"))
+	ctx.BindValue("query.page", ngin.String("2"))
+	return ctx
+}
+
+func TestCompile(t *testing.T) {
+	p := compile(t, program)
+	root := ngin.NewContext()
+	ctx := request(root, "Bearer abc")
+	if ok, err := p.Run(ctx); err != nil || !ok {
+		t.Fatal(ok, err)
+	}
+	if v := ctx.GetValue("header.user-id").String(); v != "u-Bearer abc" {
+		t.Fatalf("unexpected user id: %s", v)
+	}
+	if ctx.GetValue("response.code").Int() != 200 || ctx.GetValue("response.header.request-id").String() != "generated" {
+		t.Fatal("unexpected response")
+	}
+	if _, ok := ctx.GetValue("token").(ngin.Null); !ok {
+		t.Fatal("token shouldn't be visible outside of its block")
+	}
+	ctx = request(root, "")
+	if ok, err := p.Run(ctx); err != nil || ok {
+		t.Fatal("expect return", err)
+	}
+	if ctx.GetValue("response.code").Int() != 401 {
+		t.Fatal("expect 401")
+	}
+	if _, ok := root.GetValue("user-id").(ngin.Null); !ok {
+		t.Fatal("a request shouldn't change the root context")
+	}
+}
+
+func TestCompile_Scope(t *testing.T) {
+	p := compile(t, `
+var b d e;
+a = 1;
+a == 1 {
+	var a o;
+	a = 2;
+	o.b = 3;
+	b = o.b;
+	a == 2 {
+		a = 4;
+		var c;
+		c = a;
+		d = c;
+		g = c;
+	}
+	e = o;
+}
+f = a;
+`)
+	ctx := ngin.NewContext()
+	if _, err := p.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// var doesn't hide a variable declared outside already
+	for name, expect := range map[string]int64{"a": 4, "b": 3, "d": 4, "f": 4} {
+		if v := ctx.GetValue(name).Int(); v != expect {
+			t.Fatalf("%s: expect %d, got %d", name, expect, v)
+		}
+	}
+	if _, ok := ctx.GetValue("e").(*ngin.Complex); !ok {
+		t.Fatal("e should be the complex value of o")
+	}
+	for _, name := range []string{"c", "o", "g"} {
+		if _, ok := ctx.GetValue(name).(ngin.Null); !ok {
+			t.Fatalf("%s shouldn't be visible outside of its block", name)
+		}
+	}
+}
+
+func TestCompile_Block(t *testing.T) {
+	p := compile(t, `serve { var n; n = seen; seen = "${n}!"; }`)
+	root := ngin.NewContext()
+	var block *ngin.Block
+	root.BindFunc("serve", func(ctx *ngin.Context, args ...ngin.Value) (bool, error) {
+		block = ctx.Block()
+		return false, nil
+	})
+	if _, err := p.Run(root); err != nil {
+		t.Fatal(err)
+	}
+	if block == nil {
+		t.Fatal("expect the block of serve")
+	}
+	for i := 0; i < 2; i++ {
+		ctx := root.Folk()
+		ctx.Declare("seen")
+		ctx.BindValue("seen", ngin.String("hi"))
+		if _, err := block.Run(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if v := ctx.GetValue("seen").String(); v != "hi!" {
+			t.Fatalf("unexpected value: %s", v)
+		}
+	}
+}
+
+// BenchmarkProgram_Execute runs the statements one by one with Execute,
+// like the hosts did before Compile, so each run compiles them again
+func BenchmarkProgram_Execute(b *testing.B) {
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: strings.NewReader(program)}
+	stmts, err := p.Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+	root := ngin.NewContext()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx := request(root, "Bearer abc")
+		for _, s := range stmts {
+			if ok, err := s.Execute(ctx); err != nil || !ok {
+				b.Fatal(ok, err)
+			}
+		}
+	}
+}
+
+// BenchmarkProgram runs the compiled statements
+func BenchmarkProgram(b *testing.B) {
+	p := compile(b, program)
+	root := ngin.NewContext()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx := request(root, "Bearer abc")
+		if ok, err := p.Run(ctx); err != nil || !ok {
+			b.Fatal(ok, err)
+		}
+	}
+}
+
+func TestCompile_Def(t *testing.T) {
+	p := compile(t, `
+var user-id checked greeting;
+def authenticate token {
+    var id;
+    checked = true;
+    token ~ ^Bearer {
+        id = "u-${token}";
+        return id;
+    }
+    response.code = 401;
+    return false;
+}
+def greet name {
+    greeting = "hello ${name} from ${host}";
+}
+host = hello.com;
+greet world;
+user-id = authenticate "Bearer abc";
+authenticate header.Authorization {
+    allowed = true;
+}
+`)
+	ctx := ngin.NewContext()
+	ctx.Declare("response", "header")
+	ctx.BindValue("header.Authorization", ngin.String("Basic abc"))
+	ok, err := p.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("a function returning false as a condition shouldn't stop the caller")
+	}
+	if v := ctx.GetValue("greeting").String(); v != "hello world from hello.com" {
+		t.Fatalf("unexpected greeting: %s", v)
+	}
+	if v := ctx.GetValue("user-id").String(); v != "u-Bearer abc" {
+		t.Fatalf("unexpected user id: %s", v)
+	}
+	if !ctx.GetValue("checked").Bool() || ctx.GetValue("response.code").Int() != 401 {
+		t.Fatal("the variables of the caller should be assigned")
+	}
+	for _, name := range []string{"allowed", "id", "token"} {
+		if _, ok := ctx.GetValue(name).(ngin.Null); !ok {
+			t.Fatalf("unexpected %s", name)
+		}
+	}
+
+	p = compile(t, `def deny { return false; } { deny; reached = true; } !deny && !(deny || false == true) { denied = true; }`)
+	ctx = ngin.NewContext()
+	ctx.Declare("reached", "denied")
+	if ok, err := p.Run(ctx); err != nil || !ok || !ctx.GetValue("reached").Bool() {
+		t.Fatal("a function returning false as a statement shouldn't stop the block")
+	}
+	if !ctx.GetValue("denied").Bool() {
+		t.Fatal("a function returning false should be a failed condition")
+	}
+
+	p = compile(t, `var r; r = add 1 2; { r = r + (double 3); def double n { return n * 2; } } def add a b { return a + b; }`)
+	ctx = ngin.NewContext()
+	if _, err := p.Run(ctx); err != nil || ctx.GetValue("r").String() != "9" {
+		t.Fatalf("a function should be callable before its def, got %v, %v", ctx.GetValue("r"), err)
+	}
+
+	p = compile(t, `def loop { loop; } loop;`)
+	if _, err := p.Run(ngin.NewContext()); err == nil || !strings.Contains(err.Error(), "call depth exceeds") {
+		t.Fatalf("expect the call depth error, got %v", err)
+	}
+
+	p = compile(t, `def one a { } one 1 2;`)
+	if _, err := p.Run(ngin.NewContext()); err == nil || !strings.Contains(err.Error(), "one takes 1 arguments, got 2") {
+		t.Fatalf("expect the arguments error, got %v", err)
+	}
+}
+
+func TestCompile_Switch(t *testing.T) {
+	p := compile(t, `
+var result;
+switch subject {
+    case GET | HEAD {
+        result = read;
+    }
+    case ~ ^P {
+        result = write;
+        return;
+    }
+    case other {
+        result = other;
+    }
+    default {
+        result = unknown;
+    }
+}
+`)
+	for _, c := range []struct {
+		subject, result string
+		ok              bool
+	}{
+		{"HEAD", "read", true},
+		{"PUT", "write", false},
+		{"other", "other", true},
+		{"DELETE", "unknown", true},
+	} {
+		ctx := ngin.NewContext()
+		calls := 0
+		ctx.BindValuedFunc("subject", func(*ngin.Context, ...ngin.Value) ngin.Value {
+			calls++
+			return ngin.String(c.subject)
+		})
+		ok, err := p.Run(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != c.ok || ctx.GetValue("result").String() != c.result {
+			t.Fatalf("%s: unexpected result %s", c.subject, ctx.GetValue("result").String())
+		}
+		if calls != 1 {
+			t.Fatalf("the subject is evaluated %d times", calls)
+		}
+	}
+}
+
+func TestCompile_For(t *testing.T) {
+	p := compile(t, `
+var keys total last;
+for k, v in query {
+    keys = "${keys}${k}=${v};";
+}
+for i, item in list {
+    item == skip {
+        continue;
+    }
+    item == stop {
+        break;
+    }
+    total = "${total}${i}:${item} ";
+}
+for item in list {
+    last = item;
+    item == stop {
+        return;
+    }
+}
+`)
+	ctx := ngin.NewContext()
+	ctx.BindValue("query.b", ngin.String("2"))
+	ctx.BindValue("query.a", ngin.String("1"))
+	ctx.BindValue("query.c", ngin.String("3"))
+	ctx.BindValue("list", ngin.Slice{ngin.String("x"), ngin.String("skip"), ngin.String("y"), ngin.String("stop"), ngin.String("z")})
+	ok, err := p.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("return in a loop should stop the script")
+	}
+	if v := ctx.GetValue("keys").String(); v != "b=2;a=1;c=3;" {
+		t.Fatalf("unexpected keys: %s", v)
+	}
+	if v := ctx.GetValue("total").String(); v != "0:x 2:y " {
+		t.Fatalf("unexpected total: %s", v)
+	}
+	if v := ctx.GetValue("last").String(); v != "stop" {
+		t.Fatalf("unexpected last: %s", v)
+	}
+	for _, name := range []string{"k", "v", "i", "item"} {
+		if _, ok := ctx.GetValue(name).(ngin.Null); !ok {
+			t.Fatalf("%s shouldn't be visible outside of the loop", name)
+		}
+	}
+
+	items := make(ngin.Slice, ngin.MaxIterations+1)
+	for i := range items {
+		items[i] = ngin.Int(int64(i))
+	}
+	ctx = ngin.NewContext()
+	ctx.BindValue("list", items)
+	ctx.Declare("count")
+	p = compile(t, `for item in list { count = item; }`)
+	if ok, err := p.Run(ctx); ok || err == nil || !strings.Contains(err.Error(), "exceed the limit") {
+		t.Fatalf("expect the iteration limit error, got %v, %v", ok, err)
+	}
+	if _, ok := ctx.GetValue("count").(ngin.Null); !ok {
+		t.Fatal("no iteration should run beyond the limit")
+	}
+}
+
+func TestCompile_Arith(t *testing.T) {
+	p := compile(t, `
+var count price total label half rest negative glued star grouped zip joined sum;
+def double n {
+    return n * 2;
+}
+count = 1 + 2 * 3 - 4;
+price = query.price * 2 + 0.5;
+total = double (count + 1) + count % 2;
+label = "item-" + count + "/" + total;
+half = 7 / 2;
+rest = "${7 % 4}";
+negative = 1 - 3;
+glued = count+1;
+star = count *2;
+grouped = (1 + 2)*3;
+zip = "007" + 1;
+joined = query.price + 1;
+sum = 1 + 2.5;
+count * 2 >= 6 && count + 1 == 4 {
+    matched = true;
+}
+`)
+	ctx := ngin.NewContext()
+	ctx.Declare("matched")
+	ctx.BindValue("query.price", ngin.String("3"))
+	if _, err := p.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for name, expect := range map[string]string{
+		"count":    "3",
+		"total":    "9",
+		"label":    "item-3/9",
+		"half":     "3",
+		"rest":     "3",
+		"negative": "-2",
+		"price":    "6.5",
+		"glued":    "4",
+		"star":     "6",
+		"grouped":  "9",
+		"zip":      "0071",
+		"joined":   "31",
+		"sum":      "3.5",
+	} {
+		if v := ctx.GetValue(name).String(); v != expect {
+			t.Fatalf("%s: expect %s, got %s", name, expect, v)
+		}
+	}
+	if !ctx.GetValue("matched").Bool() {
+		t.Fatal("expect the condition with expressions to match")
+	}
+
+	for src, expect := range map[string]string{
+		`count = 1; none = count / 0;`:      "division by zero at 1, 12",
+		`def f { return 1 % 0; } none = f;`: "division by zero at 1, 9",
+		`name = abc; name - 1 > 0 { }`:      "- needs numbers, got abc at 1, 13",
+		`name = abc; none = "${name * 2}";`: "* needs numbers, got abc at 1, 13",
+		`name = abc; switch name / 2 { }`:   "/ needs numbers, got abc at 1, 13",
+		`name = abc; for i in name % 2 { }`: "% needs numbers, got abc at 1, 13",
+		`none = 9223372036854775807 + 1;`:   "integer overflow at 1, 1",
+		`none = -9223372036854775807 - 2;`:  "integer overflow at 1, 1",
+		`none = 4611686018427387904 * 2;`:   "integer overflow at 1, 1",
+	} {
+		ctx := ngin.NewContext()
+		ctx.Declare("none")
+		if _, err := compile(t, src).Run(ctx); err == nil || err.Error() != expect {
+			t.Fatalf("%s: expect %s, got %v", src, expect, err)
+		}
+		if _, ok := ctx.GetValue("none").(ngin.Null); !ok {
+			t.Fatalf("%s: a failed expression shouldn't be assigned", src)
+		}
+	}
+}
+
+func TestCompile_List(t *testing.T) {
+	p := compile(t, `
+var allowed member same;
+allowed = GET | HEAD;
+method == allowed {
+    member = true;
+}
+allowed == GET | HEAD {
+    same = true;
+}
+response.body = "${allowed}";
+`)
+	ctx := ngin.NewContext()
+	ctx.Declare("method", "response")
+	ctx.BindValue("method", ngin.String("HEAD"))
+	if _, err := p.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !ctx.GetValue("member").Bool() {
+		t.Fatal("a value should be in the list held by a variable")
+	}
+	if !ctx.GetValue("same").Bool() {
+		t.Fatal("two lists should be compared as a whole")
+	}
+	if v := ctx.GetValue("response.body").String(); v != "GET, HEAD" {
+		t.Fatalf("unexpected body: %s", v)
+	}
+}
@@ -1,11 +1,12 @@
 package ngin
 
-import (
-	"encoding/json"
-	"strings"
-)
+import "strings"
 
+// Complex is a value with named attributes, like the headers of a
+// request. The attributes keep the order they're first set in, which is
+// the order they're listed, iterated and encoded in.
 type Complex struct {
+	names      []string
 	attributes map[string]Value
 }
 
@@ -22,15 +23,15 @@ func (c *Complex) Bool() bool {
 }
 
 func (c *Complex) Compare(val Value) int {
-	panic("can't compare for complex")
+	return compare(c, val)
 }
 
-func (c *Complex) Int() uint64 {
-	panic("can't get int from complex")
+func (c *Complex) Int() int64 {
+	return 0
 }
 
 func (c *Complex) Float() float64 {
-	panic("can't get float from complex")
+	return 0
 }
 
 func (c *Complex) String() string {
@@ -38,17 +39,16 @@ func (c *Complex) String() string {
 }
 
 func (c *Complex) Bytes() []byte {
-	bs, err := json.Marshal(FromValue(c))
+	bs, err := EncodeJSON(c)
 	if err != nil {
-		panic(err)
+		// only a failed attribute or a float json can't represent, like
+		// NaN, fails
+		return nil
 	}
 	return bs
 }
 
 func (c *Complex) WithContext(ctx *Context) Value {
-	for _, v := range c.attributes {
-		v.WithContext(ctx)
-	}
 	return c
 }
 
@@ -62,24 +62,48 @@ func (c *Complex) SetAttr(attr string, val Value) {
 	}
 	idx := strings.Index(attr, ".")
 	if idx < 0 {
-		c.attributes[attr] = val
+		c.set(attr, val)
 		return
 	}
-	a, ok := c.attributes[attr[:idx]]
+	sub, ok := c.attributes[attr[:idx]].(*Complex)
 	if !ok {
-		c.attributes[attr[:idx]] = &Complex{attributes: make(map[string]Value)}
+		sub = NewComplex()
+		c.set(attr[:idx], sub)
 	}
-	if _, ok := a.(*Complex); !ok {
-		c.attributes[attr[:idx]] = &Complex{attributes: make(map[string]Value)}
+	sub.SetAttr(attr[idx+1:], val)
+}
+
+// set sets the attribute name, a new one goes after the others
+func (c *Complex) set(name string, val Value) {
+	if _, ok := c.attributes[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.attributes[name] = val
+}
+
+// Names returns the names of the attributes in order
+func (c *Complex) Names() []string {
+	return c.names
+}
+
+// clone copies c and the complex values in it
+func (c *Complex) clone() *Complex {
+	ret := &Complex{names: make([]string, len(c.names)), attributes: make(map[string]Value, len(c.attributes))}
+	copy(ret.names, c.names)
+	for k, v := range c.attributes {
+		if sub, ok := v.(*Complex); ok {
+			v = sub.clone()
+		}
+		ret.attributes[k] = v
 	}
-	c.attributes[attr[:idx]].(*Complex).SetAttr(attr[idx+1:], val)
+	return ret
 }
 
 func (c *Complex) Attr(attr string) Value {
 	sub := c.find(attr)
 	ret := []Value{}
 	if s, ok := sub.(*Complex); ok {
-		for k := range s.attributes {
+		for _, k := range s.names {
 			ret = append(ret, String(k))
 		}
 	}
@@ -100,14 +124,15 @@ func (c *Complex) find(attr string) Value {
 	}
 	if current == "*" {
 		ret := []Value{}
-		for _, sub := range c.attributes {
+		for _, name := range c.names {
+			sub := c.attributes[name]
 			if last != "" {
 				if s, ok := sub.(*Complex); ok {
 					ret = append(ret, s.find(last))
 				}
 				continue
 			}
-			ret = append(ret, c)
+			ret = append(ret, sub)
 		}
 		return Slice(ret)
 	}
@@ -1,6 +1,7 @@
 package ngin_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"testing"
 
@@ -24,21 +25,65 @@ func TestComplex_Set(t *testing.T) {
 func TestComplex_Find(t *testing.T) {
 	complex := ngin.NewComplex()
 	for i := 0; i < 10; i++ {
-		complex.SetAttr(fmt.Sprintf("hello.%d.world", i), ngin.Int(uint64(i)))
+		complex.SetAttr(fmt.Sprintf("hello.%d.world", i), ngin.Int(int64(i)))
 	}
 	attrs := ngin.Slice(complex.Attr("hello").Slice())
 	if len(attrs) != 10 {
 		t.Fatal("attr length")
 	}
 	for i := 0; i < 10; i++ {
-		if !attrs.Contain(ngin.Int(uint64(i))) {
+		if !attrs.Contain(ngin.Int(int64(i))) {
 			t.Fatalf("attr at %d", i)
 		}
 	}
 	values := ngin.Slice(complex.AttrValue("hello.*.world").Slice())
 	for i := 0; i < 10; i++ {
-		if !values.Contain(ngin.Int(uint64(i))) {
+		if !values.Contain(ngin.Int(int64(i))) {
 			t.Fatalf("attr value at %d", i)
 		}
 	}
 }
+
+func TestComplex_Order(t *testing.T) {
+	complex := ngin.NewComplex()
+	complex.SetAttr("zeta", ngin.Int(1))
+	complex.SetAttr("data.session_id", ngin.String("s"))
+	complex.SetAttr("alpha", ngin.Bool(true))
+	complex.SetAttr("data.expire", ngin.Float(1.5))
+	complex.SetAttr("zeta", ngin.Int(2))
+	if names := complex.Names(); len(names) != 3 || names[0] != "zeta" || names[2] != "alpha" {
+		t.Fatalf("unexpected names %v", names)
+	}
+	expect := `{"zeta":2,"data":{"session_id":"s","expire":1.5},"alpha":true}`
+	for i := 0; i < 10; i++ {
+		if s := complex.String(); s != expect {
+			t.Fatalf("unexpected json %s", s)
+		}
+	}
+	if v := complex.Attr("data").String(); v != "session_id, expire" {
+		t.Fatalf("unexpected attributes %s", v)
+	}
+	if v := complex.AttrValue("*").String(); v != `2, {"session_id":"s","expire":1.5}, true` {
+		t.Fatalf("unexpected values %s", v)
+	}
+
+	m := ngin.NewMap()
+	if err := json.Unmarshal([]byte(expect), m); err != nil {
+		t.Fatal(err)
+	}
+	decoded := ngin.ToValue(m)
+	if s := decoded.String(); s != expect {
+		t.Fatalf("unexpected json after decoding %s", s)
+	}
+	if v := decoded.(*ngin.Complex).AttrValue("data.expire"); v.Float() != 1.5 {
+		t.Fatalf("unexpected expire %v", v)
+	}
+	b, err := json.Marshal(ngin.FromValue(decoded))
+	if err != nil || string(b) != expect {
+		t.Fatalf("unexpected json from the go value %s %v", b, err)
+	}
+	v := ngin.ToValue(map[string]any{"b": 1, "c": 2, "a": 3})
+	if s := v.String(); s != `{"a":3,"b":1,"c":2}` {
+		t.Fatalf("the keys of a map should be sorted, got %s", s)
+	}
+}
@@ -1,8 +1,14 @@
 package ngin
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/dev-mockingbird/logf"
 )
@@ -11,7 +17,7 @@ type Func func(ctx *Context, values ...Value) (bool, error)
 type ValuedFunc func(ctx *Context, values ...Value) Value
 
 type Value interface {
-	Int() uint64
+	Int() int64
 	Float() float64
 	String() string
 	Bytes() []byte
@@ -22,7 +28,37 @@ type Value interface {
 	Value() Value
 }
 
+// ToValue converts a go value to a Value. The maps with string keys and
+// the structs are complex values, the attributes of a map are sorted by
+// their names, the ones of a Map keep its order and the ones of a struct
+// the order of the fields. A json.Number is an int or a float, a
+// time.Duration a duration and a time.Time a time, nil is null.
 func ToValue(m any) Value {
+	switch m := m.(type) {
+	case nil:
+		return Null{}
+	case Value:
+		return m
+	case *Map:
+		ret := NewComplex()
+		for _, k := range m.keys {
+			ret.set(k, ToValue(m.values[k]))
+		}
+		return ret
+	case time.Duration:
+		return Duration(m)
+	case time.Time:
+		return Time(m)
+	case json.Number:
+		i, f, isInt, ok := parseNumber(string(m))
+		switch {
+		case isInt:
+			return Int(i)
+		case ok:
+			return Float(f)
+		}
+		return String(string(m))
+	}
 	rv := reflect.ValueOf(m)
 	for rv.Kind() == reflect.Ptr {
 		rv = rv.Elem()
@@ -43,39 +79,31 @@ func ToValue(m any) Value {
 		return Float(float64(rv.Interface().(float32)))
 	case reflect.Float64:
 		return Float(rv.Interface().(float64))
-	case reflect.Uint:
-		return Int(uint64(uint64(rv.Interface().(uint))))
-	case reflect.Uint8:
-		return Int(uint64(uint64(rv.Interface().(uint8))))
-	case reflect.Uint16:
-		return Int(uint64(rv.Interface().(uint16)))
-	case reflect.Uint32:
-		return Int(uint64(rv.Interface().(uint32)))
-	case reflect.Uint64:
-		return Int(uint64(rv.Interface().(uint64)))
-	case reflect.Int:
-		return Int(uint64(uint64(rv.Interface().(int))))
-	case reflect.Int8:
-		return Int(uint64(uint64(rv.Interface().(int8))))
-	case reflect.Int16:
-		return Int(uint64(rv.Interface().(int16)))
-	case reflect.Int32:
-		return Int(uint64(rv.Interface().(int32)))
-	case reflect.Int64:
-		return Int(uint64(rv.Interface().(int64)))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := rv.Uint()
+		if u > math.MaxInt64 {
+			return Float(float64(u))
+		}
+		return Int(int64(u))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return Int(rv.Int())
 	case reflect.String:
-		return String(rv.Interface().(string))
+		return String(rv.String())
 	case reflect.Map:
 		ret := NewComplex()
-		for _, k := range rv.MapKeys() {
-			if k.Kind() != reflect.String {
-				continue
-			}
+		if rv.Type().Key().Kind() != reflect.String {
+			return ret
+		}
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		for _, k := range keys {
 			val := rv.MapIndex(k)
-			if !val.CanInterface() || !k.CanInterface() {
+			if !val.CanInterface() {
 				continue
 			}
-			ret.SetAttr(k.Interface().(string), ToValue(val.Interface()))
+			ret.set(k.String(), ToValue(val.Interface()))
 		}
 		return ret
 	case reflect.Struct:
@@ -86,18 +114,19 @@ func ToValue(m any) Value {
 			if !fv.CanInterface() {
 				continue
 			}
-			ret.SetAttr(k, ToValue(fv.Interface()))
+			ret.set(k, ToValue(fv.Interface()))
 		}
 		return ret
 	}
-	return nil
+	return Null{}
 }
 
 func FromValue(v Value) any {
+	v = v.Value()
 	if c, ok := v.(*Complex); ok {
-		ret := make(map[string]any)
-		for k, val := range c.attributes {
-			ret[k] = FromValue(val)
+		ret := NewMap()
+		for _, k := range c.names {
+			ret.Set(k, FromValue(c.attributes[k]))
 		}
 		return ret
 	} else if s, ok := v.(Slice); ok {
@@ -112,6 +141,8 @@ func FromValue(v Value) any {
 		return s.content
 	} else if s, ok := v.(it); ok {
 		return s.value
+	} else if s, ok := v.(flt); ok {
+		return s.value
 	} else if s, ok := v.(bol); ok {
 		return s.value
 	} else if s, ok := v.(bs); ok {
@@ -124,34 +155,48 @@ type Variable struct {
 	Name    string
 	Args    []Value
 	Context *Context
+	// ref is set if the variable is compiled
+	ref *varRef
 }
 
+// WithContext returns a copy of the variable bound to ctx, the variable
+// itself isn't changed as it may be shared by many requests
 func (v *Variable) WithContext(ctx *Context) Value {
-	v.Context = ctx
-	return v
+	return &Variable{Name: v.Name, Args: v.Args, Context: ctx, ref: v.ref}
 }
 
 func (v *Variable) Value() Value {
 	if v.Context == nil {
 		return Null{}
 	}
-	if f := v.Context.GetValuedFunc(v.Name); f != nil {
-		return f(v.Context, func() []Value {
-			for i := 0; i < len(v.Args); i++ {
-				if item, ok := v.Args[i].(*Variable); ok {
-					item.Context = v.Context
-				}
-			}
-			return v.Args
-		}()...)
+	if v.ref != nil {
+		return v.ref.value(v.Context, v.Args)
+	}
+	return v.Context.value(v.Name, v.Args)
+}
+
+// value returns the result of the valued function name if there is one,
+// otherwise the value of the variable name if it's declared, otherwise
+// name itself
+func (ctx *Context) value(name string, args []Value) Value {
+	if f := ctx.GetValuedFunc(name); f != nil {
+		return f(ctx, bindArgs(ctx, args)...)
 	}
-	if v.Context.IsVar(v.Name) {
-		return v.Context.GetValue(v.Name)
+	if ctx.IsVar(name) {
+		return ctx.GetValue(name)
 	}
-	return String(v.Name)
+	return String(name)
 }
 
-func (v *Variable) Int() uint64 {
+func bindArgs(ctx *Context, args []Value) []Value {
+	ret := make([]Value, len(args))
+	for i, arg := range args {
+		ret[i] = arg.WithContext(ctx)
+	}
+	return ret
+}
+
+func (v *Variable) Int() int64 {
 	return v.Value().Int()
 }
 
@@ -179,24 +224,43 @@ func (v Variable) Compare(r Value) int {
 	return v.Value().Compare(r)
 }
 
+// Context is a frame of the running script: the variables and the
+// functions bound in it, and its parent frame. The frame of a compiled
+// block has slots for the names declared by var in the block, the other
+// names are kept in maps, which are allocated on the first write so
+// frames are cheap to create.
 type Context struct {
 	variables   *Complex
 	valuedFunks map[string]ValuedFunc
 	vars        map[string]struct{}
+	provided    map[string]struct{}
+	docs        map[string]string
 	funks       map[string]Func
 	logger      logf.Logger
 	bag         map[string]any
-	stmts       []Stmt
 	parent      *Context
+	// scope names the slots, it's nil if the frame isn't made for a block
+	scope *scope
+	slots []slot
+	// ret is where the frame of a function call keeps the returned value
+	ret *Value
+	// mu is set once the frame is shared by the requests, see Share
+	mu *sync.RWMutex
+	// block is the block of the MatchThenStmt whose condition is being
+	// evaluated, a function in the condition may run it
+	block *Block
+	// pos is where the statement being executed begins
+	pos Pos
+}
+
+type slot struct {
+	value    Value
+	declared bool
 }
 
 func NewContext() *Context {
 	return &Context{
-		variables:   NewComplex(),
-		vars:        make(map[string]struct{}),
-		valuedFunks: make(map[string]ValuedFunc),
-		bag:         make(map[string]any),
-		logger:      logf.New(),
+		logger: logf.New(),
 		funks: map[string]Func{
 			"var": defineVar,
 		},
@@ -204,53 +268,275 @@ func NewContext() *Context {
 }
 
 func (ctx *Context) Folk() *Context {
-	child := NewContext()
-	child.parent = ctx
-	child.stmts = ctx.stmts
+	return &Context{parent: ctx, logger: ctx.logger, pos: ctx.pos}
+}
+
+// enter makes the frame of a block
+func (ctx *Context) enter(sc *scope) *Context {
+	child := &Context{parent: ctx, logger: ctx.logger, pos: ctx.pos, scope: sc}
+	if len(sc.names) > 0 {
+		child.slots = make([]slot, len(sc.names))
+	}
 	return child
 }
 
+// Share makes ctx and the frames above it shared by the requests run at
+// the same time in the frames folked from ctx. A shared frame isn't
+// changed by the scripts anymore: a request assigning a variable declared
+// in it gets its own copy of the variable in the frame of the request.
+// The host may still bind values and functions in a shared frame, the
+// frame is locked. The docs and the provided names are bound before.
+func (ctx *Context) Share() {
+	for c := ctx; c != nil && c.mu == nil; c = c.parent {
+		c.mu = &sync.RWMutex{}
+	}
+}
+
+func (ctx *Context) shared() bool {
+	return ctx.mu != nil
+}
+
+func (ctx *Context) lock() {
+	if ctx.mu != nil {
+		ctx.mu.Lock()
+	}
+}
+
+func (ctx *Context) unlock() {
+	if ctx.mu != nil {
+		ctx.mu.Unlock()
+	}
+}
+
+func (ctx *Context) rlock() {
+	if ctx.mu != nil {
+		ctx.mu.RLock()
+	}
+}
+
+func (ctx *Context) runlock() {
+	if ctx.mu != nil {
+		ctx.mu.RUnlock()
+	}
+}
+
+// writable returns the frame where a variable declared in target is
+// written from ctx: target itself, or the frame of the request if target
+// is shared, the variable is copied to it then
+func (ctx *Context) writable(target *Context, root string) *Context {
+	if !target.shared() || ctx.shared() {
+		return target
+	}
+	req := ctx
+	for req.parent != nil && !req.parent.shared() {
+		req = req.parent
+	}
+	target.rlock()
+	v := target.getValue(root)
+	target.runlock()
+	req.declare(root)
+	if c, ok := v.(*Complex); ok {
+		v = c.clone()
+	}
+	if _, ok := v.(Null); !ok {
+		req.bindValue(root, v)
+	}
+	return req
+}
+
+// result returns where the value returned by the running function is
+// kept, it's nil out of a function
+func (ctx *Context) result() *Value {
+	for c := ctx; c != nil; c = c.parent {
+		if c.ret != nil {
+			return c.ret
+		}
+	}
+	return nil
+}
+
+// Block returns the block whose condition is being evaluated, functions
+// like listen take it to run it later
+func (ctx *Context) Block() *Block {
+	return ctx.block
+}
+
 func (ctx *Context) SetLogger(logger logf.Logger) {
 	ctx.logger = logger
 }
 
+// Logger returns the logger of the context, the messages are prefixed
+// with the position of the statement being executed
 func (ctx *Context) Logger() logf.Logfer {
-	return ctx.logger
+	if ctx.pos.Row == 0 {
+		return ctx.logger
+	}
+	return ctx.logger.Prefix(fmt.Sprintf("[%s] ", ctx.pos.String()))
+}
+
+// Pos returns where the statement being executed begins
+func (ctx *Context) Pos() Pos {
+	return ctx.pos
 }
 
 func (ctx *Context) Declare(names ...string) {
+	ctx.lock()
+	defer ctx.unlock()
 	for _, name := range names {
-		ctx.vars[name] = struct{}{}
+		ctx.declare(name)
 	}
 }
 
-func (ctx *Context) BindValue(key string, val Value) {
-	if cctx := ctx.declareVarAt(key); cctx != nil {
-		cctx.bindValue(key, val)
+func (ctx *Context) declare(name string) {
+	if i, ok := ctx.slotOf(name); ok {
+		ctx.slots[i].declared = true
 		return
 	}
-	ctx.bindValue(key, val)
+	if ctx.vars == nil {
+		ctx.vars = make(map[string]struct{})
+	}
+	ctx.vars[name] = struct{}{}
+}
+
+// slotOf returns the slot of name if the frame has one
+func (ctx *Context) slotOf(name string) (int, bool) {
+	if ctx.scope == nil {
+		return 0, false
+	}
+	i, ok := ctx.scope.index[name]
+	return i, ok
+}
+
+// Provide tells the names the host binds when the script runs, like the
+// request variables of listen, so Check doesn't report them
+func (ctx *Context) Provide(names ...string) {
+	if ctx.provided == nil {
+		ctx.provided = make(map[string]struct{})
+	}
+	for _, name := range names {
+		ctx.provided[name] = struct{}{}
+	}
+}
+
+func (ctx *Context) IsProvided(name string) bool {
+	if _, ok := ctx.provided[name]; ok {
+		return true
+	}
+	if ctx.parent != nil {
+		return ctx.parent.IsProvided(name)
+	}
+	return false
+}
+
+// Describe documents a function or a variable, e.g. to be shown by editors
+func (ctx *Context) Describe(name, doc string) {
+	if ctx.docs == nil {
+		ctx.docs = make(map[string]string)
+	}
+	ctx.docs[name] = doc
+}
+
+func (ctx *Context) Doc(name string) string {
+	if doc, ok := ctx.docs[name]; ok {
+		return doc
+	}
+	if ctx.parent != nil {
+		return ctx.parent.Doc(name)
+	}
+	return ""
+}
+
+// Described returns the names documented with Describe, in order
+func (ctx *Context) Described() []string {
+	names := []string{}
+	for c := ctx; c != nil; c = c.parent {
+		for name := range c.docs {
+			names = append(names, name)
+		}
+	}
+	return sortedNames(names)
+}
+
+// FuncNames returns the names of the functions and the valued functions
+// bound, in order
+func (ctx *Context) FuncNames() []string {
+	names := []string{}
+	for c := ctx; c != nil; c = c.parent {
+		for name := range c.funks {
+			names = append(names, name)
+		}
+		for name := range c.valuedFunks {
+			names = append(names, name)
+		}
+	}
+	return sortedNames(names)
+}
+
+func sortedNames(names []string) []string {
+	sort.Strings(names)
+	ret := names[:0]
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+func (ctx *Context) BindValue(key string, val Value) {
+	cctx := ctx
+	if target := ctx.declareVarAt(key); target != nil {
+		root, _ := splitName(key)
+		cctx = ctx.writable(target, root)
+	}
+	cctx.lock()
+	defer cctx.unlock()
+	cctx.bindValue(key, val)
 }
 
 func (ctx *Context) bindValue(key string, val Value) {
+	root, rest := splitName(key)
+	if i, ok := ctx.slotOf(root); ok {
+		ctx.slots[i].declared = true
+		ctx.setSlot(i, rest, val.Value())
+		return
+	}
+	if ctx.variables == nil {
+		ctx.variables = NewComplex()
+	}
 	ctx.variables.SetAttr(key, val.Value())
-	if idx := strings.Index(key, "."); idx > -1 {
-		if _, ok := ctx.vars[key[:idx]]; !ok {
-			ctx.vars[key[:idx]] = struct{}{}
-		}
+	if !ctx.isDeclared(root) {
+		ctx.declare(root)
+	}
+}
+
+func (ctx *Context) setSlot(i int, rest string, val Value) {
+	if rest == "" {
+		ctx.slots[i].value = val
 		return
 	}
-	if _, ok := ctx.vars[key]; !ok {
-		ctx.vars[key] = struct{}{}
+	c, ok := ctx.slots[i].value.(*Complex)
+	if !ok {
+		c = NewComplex()
+		ctx.slots[i].value = c
 	}
+	c.SetAttr(rest, val)
 }
 
 func (ctx *Context) Put(name string, val any) {
+	ctx.lock()
+	defer ctx.unlock()
+	if ctx.bag == nil {
+		ctx.bag = make(map[string]any)
+	}
 	ctx.bag[name] = val
 }
 
 func (ctx *Context) Get(name string) any {
-	if v, ok := ctx.bag[name]; ok {
+	ctx.rlock()
+	v, ok := ctx.bag[name]
+	ctx.runlock()
+	if ok {
 		return v
 	}
 	if ctx.parent != nil {
@@ -265,22 +551,19 @@ func defineVar(ctx *Context, args ...Value) (bool, error) {
 			if ctx.IsVar(v.Name) {
 				continue
 			}
-			ctx.vars[v.Name] = struct{}{}
+			ctx.Declare(v.Name)
 		}
 	}
 	return true, nil
 }
 
 func (ctx *Context) declareVarAt(name string) *Context {
-	idx := strings.Index(name, ".")
-	if idx > -1 {
-		name = name[:idx]
-	}
-	return ctx.declareAt(name)
+	root, _ := splitName(name)
+	return ctx.declareAt(root)
 }
 
 func (ctx *Context) declareAt(name string) *Context {
-	if _, ok := ctx.vars[name]; ok {
+	if ctx.isVar(name) {
 		return ctx
 	}
 	if ctx.parent != nil {
@@ -300,16 +583,24 @@ func (ctx *Context) IsVar(name string) bool {
 }
 
 func (ctx *Context) isVar(name string) bool {
-	if idx := strings.Index(name, "."); idx > -1 {
-		_, ok := ctx.vars[name[:idx]]
-		return ok
+	root, _ := splitName(name)
+	ctx.rlock()
+	defer ctx.runlock()
+	return ctx.isDeclared(root)
+}
+
+func (ctx *Context) isDeclared(name string) bool {
+	if i, ok := ctx.slotOf(name); ok && ctx.slots[i].declared {
+		return true
 	}
 	_, ok := ctx.vars[name]
 	return ok
 }
 
 func (ctx *Context) GetValue(key string) Value {
+	ctx.rlock()
 	v := ctx.getValue(key)
+	ctx.runlock()
 	if _, ok := v.(Null); !ok && v != nil {
 		return v
 	}
@@ -320,9 +611,13 @@ func (ctx *Context) GetValue(key string) Value {
 	return Null{}
 }
 
+// GetAttr returns the names of the attributes of key, from the nearest
+// frame where key is a complex value
 func (ctx *Context) GetAttr(key string) Value {
+	ctx.rlock()
 	v := ctx.getAttr(key)
-	if _, ok := v.(Null); ok {
+	ctx.runlock()
+	if _, ok := v.(Null); !ok {
 		return v
 	}
 	if ctx.parent != nil {
@@ -332,7 +627,10 @@ func (ctx *Context) GetAttr(key string) Value {
 }
 
 func (ctx *Context) GetValuedFunc(name string) ValuedFunc {
-	if funk, ok := ctx.valuedFunks[name]; ok {
+	ctx.rlock()
+	funk, ok := ctx.valuedFunks[name]
+	ctx.runlock()
+	if ok {
 		return funk
 	}
 	if ctx.parent != nil {
@@ -342,7 +640,10 @@ func (ctx *Context) GetValuedFunc(name string) ValuedFunc {
 }
 
 func (ctx *Context) GetFunc(name string) Func {
-	if funk, ok := ctx.funks[name]; ok {
+	ctx.rlock()
+	funk, ok := ctx.funks[name]
+	ctx.runlock()
+	if ok {
 		return funk
 	}
 	if ctx.parent != nil {
@@ -352,26 +653,71 @@ func (ctx *Context) GetFunc(name string) Func {
 }
 
 func (ctx *Context) getValue(key string) Value {
+	root, rest := splitName(key)
+	if i, ok := ctx.slotOf(root); ok {
+		return ctx.slotValue(i, rest)
+	}
+	if ctx.variables == nil {
+		return Null{}
+	}
 	return ctx.variables.AttrValue(key)
 }
 
+func (ctx *Context) slotValue(i int, rest string) Value {
+	v := ctx.slots[i].value
+	if v == nil {
+		return Null{}
+	}
+	if rest == "" {
+		return v
+	}
+	if c, ok := v.(*Complex); ok {
+		return c.AttrValue(rest)
+	}
+	return Null{}
+}
+
 func (ctx *Context) getAttr(key string) Value {
-	return ctx.variables.Attr(key)
+	c, ok := ctx.getValue(key).(*Complex)
+	if !ok {
+		return Null{}
+	}
+	ret := make(Slice, len(c.names))
+	for i, k := range c.names {
+		ret[i] = String(k)
+	}
+	return ret
 }
 
 func (ctx *Context) BindFunc(name string, funk Func) {
+	ctx.lock()
+	defer ctx.unlock()
+	if ctx.funks == nil {
+		ctx.funks = make(map[string]Func)
+	}
 	ctx.funks[name] = funk
 }
 
 func (ctx *Context) BindValuedFunc(name string, funk ValuedFunc) {
-	if cctx := ctx.declareAt(name); cctx != nil {
-		cctx.valuedFunks[name] = funk
-		return
+	cctx := ctx
+	if target := ctx.declareAt(name); target != nil {
+		cctx = ctx.writable(target, name)
 	}
-	ctx.valuedFunks[name] = funk
-	ctx.Declare(name)
+	cctx.lock()
+	defer cctx.unlock()
+	if !cctx.isDeclared(name) {
+		cctx.declare(name)
+	}
+	if cctx.valuedFunks == nil {
+		cctx.valuedFunks = make(map[string]ValuedFunc)
+	}
+	cctx.valuedFunks[name] = funk
 }
 
-func (ctx *Context) NextStmts() []Stmt {
-	return ctx.stmts
+// splitName splits a.b.c into a and b.c
+func splitName(name string) (root, rest string) {
+	if idx := strings.Index(name, "."); idx > -1 {
+		return name[:idx], name[idx+1:]
+	}
+	return name, ""
 }
@@ -19,13 +19,15 @@ func TestFromValue(t *testing.T) {
 	c.SetAttr("hello.world2", ngin.Bytes([]byte("hello world 2")))
 	c.SetAttr("hello.world3", ngin.Int(123))
 	c.SetAttr("hello.world4", ngin.Slice([]ngin.Value{ngin.String("h")}))
+	c.SetAttr("hello.world5", ngin.Int(-5))
+	c.SetAttr("hello.world6", ngin.Float(1.5))
 
 	result := ngin.FromValue(c)
 	bs, err := json.Marshal(result)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if string(bs) != `{"hello":{"world":"hello world","world1":"hello world 1","world2":"hello world 2","world3":123,"world4":["h"]}}` {
+	if string(bs) != `{"hello":{"world":"hello world","world1":"hello world 1","world2":"hello world 2","world3":123,"world4":["h"],"world5":-5,"world6":1.5}}` {
 		t.Fatal("not equal")
 	}
 }
@@ -38,6 +40,8 @@ func TestToValue(t *testing.T) {
 			"world2": "hello world 2",
 			"world3": 123,
 			"world4": []string{"h"},
+			"world5": int8(-5),
+			"world6": uint64(1 << 63),
 		},
 	}
 	result := ngin.ToValue(v)
@@ -51,9 +55,12 @@ func TestToValue(t *testing.T) {
 
 		t.Fatal("string error")
 	}
-	if c.AttrValue("hello.world3").Int() != 123 {
+	if c.AttrValue("hello.world3").Int() != 123 || c.AttrValue("hello.world5").Int() != -5 {
 		t.Fatal("int error")
 	}
+	if c.AttrValue("hello.world6").Float() != 1<<63 {
+		t.Fatal("an uint64 out of the range of int64 should be a float")
+	}
 	_, ok = c.AttrValue("hello.world4").(ngin.Slice)
 	if !ok {
 		t.Fatal("slice error")
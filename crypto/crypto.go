@@ -0,0 +1,174 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"time"
+
+	"github.com/dev-mockingbird/ngin"
+	"github.com/google/uuid"
+)
+
+// MaxRandomBytes is the most bytes random-bytes returns at once
+const MaxRandomBytes = 1024
+
+func Init(ctx *ngin.Context) {
+	ctx.BindValuedFunc("md5", digest("md5", md5.New))
+	ctx.BindValuedFunc("sha1", digest("sha1", sha1.New))
+	ctx.BindValuedFunc("sha256", digest("sha256", sha256.New))
+	ctx.BindValuedFunc("sha512", digest("sha512", sha512.New))
+	ctx.BindValuedFunc("hmac-sha256", HmacSha256)
+	ctx.BindValuedFunc("crc32", Crc32)
+	ctx.BindValuedFunc("secure-compare", SecureCompare)
+	// it's a condition too, like `secure-compare signature expected { }`
+	ctx.BindFunc("secure-compare", ngin.Condition(SecureCompare))
+	ctx.BindValuedFunc("random-bytes", RandomBytes)
+	ctx.BindValuedFunc("uuid", UUID)
+	ctx.BindValuedFunc("encode-hex", EncodeHex)
+	ctx.BindValuedFunc("decode-hex", DecodeHex)
+	for _, name := range []string{"md5", "sha1", "sha256", "sha512"} {
+		ctx.Describe(name, fmt.Sprintf("%s value\n\nreturns the %s digest of value as bytes, see encode-hex", name, name))
+	}
+	ctx.Describe("hmac-sha256", "hmac-sha256 key value\n\nreturns the HMAC-SHA256 of value signed with key as bytes")
+	ctx.Describe("crc32", "crc32 value\n\nreturns the IEEE CRC-32 checksum of value as an int")
+	ctx.Describe("secure-compare", "secure-compare a b\n\nreports whether a and b are equal in a time which doesn't depend on their content, for comparing signatures and secrets")
+	ctx.Describe("random-bytes", fmt.Sprintf("random-bytes count\n\nreturns count cryptographically secure random bytes, at most %d", MaxRandomBytes))
+	ctx.Describe("uuid", "uuid [version]\n\nreturns a random UUID, version is 4 (the default) or 7 for one ordered by time")
+	ctx.Describe("encode-hex", "encode-hex value\n\nencodes value as lower case hex")
+	ctx.Describe("decode-hex", "decode-hex value\n\ndecodes hex")
+}
+
+// digest returns the function hashing its argument with the hash made by h
+func digest(name string, h func() hash.Hash) ngin.ValuedFunc {
+	return func(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+		vs, err := ngin.EvalArgs(ctx, name, args, 1)
+		if err != nil {
+			return ngin.Fail(err)
+		}
+		w := h()
+		w.Write(vs[0].Bytes())
+		return ngin.Bytes(w.Sum(nil))
+	}
+}
+
+func HmacSha256(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "hmac-sha256", args, 2)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	mac := hmac.New(sha256.New, vs[0].Bytes())
+	mac.Write(vs[1].Bytes())
+	return ngin.Bytes(mac.Sum(nil))
+}
+
+func Crc32(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "crc32", args, 1)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	return ngin.Int(int64(crc32.ChecksumIEEE(vs[0].Bytes())))
+}
+
+func SecureCompare(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "secure-compare", args, 2)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	return ngin.Bool(subtle.ConstantTimeCompare(vs[0].Bytes(), vs[1].Bytes()) == 1)
+}
+
+func RandomBytes(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "random-bytes", args, 1)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	n, err := ngin.ToInt(vs[0])
+	if err != nil {
+		return ngin.Fail(fmt.Errorf("random-bytes: %w", err))
+	}
+	if n < 0 || n > MaxRandomBytes {
+		return ngin.Fail(fmt.Errorf("random-bytes: count %d isn't between 0 and %d", n, MaxRandomBytes))
+	}
+	ret := make([]byte, n)
+	if _, err := rand.Read(ret); err != nil {
+		return ngin.Fail(fmt.Errorf("random-bytes: %w", err))
+	}
+	return ngin.Bytes(ret)
+}
+
+func UUID(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "uuid", args, 0)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	version := int64(4)
+	if len(vs) > 0 {
+		if version, err = ngin.ToInt(vs[0]); err != nil {
+			return ngin.Fail(fmt.Errorf("uuid: version: %w", err))
+		}
+	}
+	var id uuid.UUID
+	switch version {
+	case 4:
+		id, err = uuid.NewRandom()
+	case 7:
+		id, err = newV7(time.Now())
+	default:
+		return ngin.Fail(fmt.Errorf("uuid: unsupported version %d", version))
+	}
+	if err != nil {
+		return ngin.Fail(fmt.Errorf("uuid: %w", err))
+	}
+	return ngin.String(id.String())
+}
+
+// newV7 returns a version 7 UUID, as defined by RFC 9562: the unix time
+// in milliseconds of t in the first 48 bits, followed by random bits but
+// the version and the variant
+func newV7(t time.Time) (uuid.UUID, error) {
+	var id uuid.UUID
+	if _, err := rand.Read(id[6:]); err != nil {
+		return id, err
+	}
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(t.UnixMilli()))
+	copy(id[:6], ms[2:])
+	id[6] = id[6]&0x0f | 0x70
+	id[8] = id[8]&0x3f | 0x80
+	return id, nil
+}
+
+func EncodeHex(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "encode-hex", args, 1)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	return ngin.String(hex.EncodeToString(vs[0].Bytes()))
+}
+
+func DecodeHex(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "decode-hex", args, 1)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	ret, err := hex.DecodeString(vs[0].String())
+	if err != nil {
+		return ngin.Fail(fmt.Errorf("decode-hex: %w", err))
+	}
+	return ngin.Bytes(ret)
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package crypto_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/dev-mockingbird/ngin"
+	"github.com/dev-mockingbird/ngin/crypto"
+	"github.com/dev-mockingbird/ngin/ngintest"
+)
+
+func TestCrypto(t *testing.T) {
+	ctx, err := ngintest.Run(t, `
+digest-md5 = encode-hex (md5 abc);
+digest-sha1 = encode-hex (sha1 abc);
+digest-sha256 = encode-hex (sha256 abc);
+digest-sha512 = encode-hex (sha512 "");
+signature = encode-hex (hmac-sha256 key "The quick brown fox jumps over the lazy dog");
+crc = crc32 "hello world";
+raw = decode-hex 616263;
+random = encode-hex (random-bytes 16);
+v4 = uuid;
+v7 = uuid 7;
+equal = secure-compare (sha256 abc) (decode-hex digest-sha256);
+secure-compare signature f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8 && !secure-compare signature "" {
+    matched = true;
+}
+`, crypto.Init, "digest-md5", "digest-sha1", "digest-sha256", "digest-sha512", "signature", "crc", "raw", "random", "v4", "v7", "equal", "matched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expect := range map[string]string{
+		"digest-md5":    "900150983cd24fb0d6963f7d28e17f72",
+		"digest-sha1":   "a9993e364706816aba3e25717850c26c9cd0d89d",
+		"digest-sha256": "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		"digest-sha512": "cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e",
+		"signature":     "f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		"crc":           "222957957",
+		"raw":           "abc",
+		"equal":         "true",
+		"matched":       "true",
+	} {
+		if v := ctx.GetValue(name).String(); v != expect {
+			t.Fatalf("%s: expect %s, got %s", name, expect, v)
+		}
+	}
+	if v := ctx.GetValue("random").String(); len(v) != 32 {
+		t.Fatalf("expect 16 random bytes, got %s", v)
+	}
+	for name, version := range map[string]string{"v4": "4", "v7": "7"} {
+		re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-` + version + `[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+		if v := ctx.GetValue(name).String(); !re.MatchString(v) {
+			t.Fatalf("%s: unexpected uuid %s", name, v)
+		}
+	}
+}
+
+func TestUUID_V7Order(t *testing.T) {
+	prev := ""
+	for i := 0; i < 3; i++ {
+		ctx, err := ngintest.Run(t, `id = uuid 7;`, crypto.Init, "id")
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := ctx.GetValue("id").String()
+		// the first 48 bits are the time in milliseconds
+		if id[:13] < prev {
+			t.Fatalf("expect %s to come after %s", id, prev)
+		}
+		prev = id[:13]
+	}
+}
+
+func TestCrypto_Fail(t *testing.T) {
+	for src, expect := range map[string]string{
+		`none = sha256;`:            "sha256: too few arguments, got 0 at 1, 1",
+		`none = decode-hex xyz;`:    "decode-hex: encoding/hex: invalid byte: U+0078 'x' at 1, 1",
+		`none = random-bytes x;`:    "random-bytes: x isn't a number at 1, 1",
+		`none = random-bytes -1;`:   "random-bytes: count -1 isn't between 0 and 1024 at 1, 1",
+		`none = random-bytes 2048;`: "random-bytes: count 2048 isn't between 0 and 1024 at 1, 1",
+		`none = uuid 1;`:            "uuid: unsupported version 1 at 1, 1",
+	} {
+		ctx, err := ngintest.Run(t, src, crypto.Init, "none")
+		if err == nil || err.Error() != expect {
+			t.Fatalf("%s: expect %s, got %v", src, expect, err)
+		}
+		if _, ok := ctx.GetValue("none").(ngin.Null); !ok {
+			t.Fatalf("%s: a failed value shouldn't be assigned", src)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// dur is a duration, written in a script like `30s`, `1.5h` or `1h30m`
+type dur struct {
+	value time.Duration
+}
+
+func Duration(value time.Duration) Value {
+	return dur{value: value}
+}
+
+func (d dur) WithContext(*Context) Value {
+	return d
+}
+
+// Int is the number of whole seconds
+func (d dur) Int() int64 {
+	return int64(d.value / time.Second)
+}
+
+// Float is the number of seconds
+func (d dur) Float() float64 {
+	return d.value.Seconds()
+}
+
+// String is the duration as it's written in a script, like `1h30m`
+func (d dur) String() string {
+	s := d.value.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+func (d dur) Bytes() []byte {
+	return []byte(d.String())
+}
+
+func (d dur) Bool() bool {
+	return d.value != 0
+}
+
+func (d dur) Compare(val Value) int {
+	return compare(d, val)
+}
+
+func (d dur) Slice() []Value {
+	return []Value{d}
+}
+
+func (d dur) Value() Value {
+	return d
+}
+
+// ToDuration converts v to a duration: a number is a number of seconds and
+// a string is parsed by time.ParseDuration, like "30s". It fails for
+// anything else.
+func ToDuration(v Value) (time.Duration, error) {
+	v = v.Value()
+	if err := Err(v); err != nil {
+		return 0, err
+	}
+	if d, ok := v.(dur); ok {
+		return d.value, nil
+	}
+	if i, f, isInt, ok := number(v); ok {
+		if isInt {
+			return time.Duration(i) * time.Second, nil
+		}
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	switch v.(type) {
+	case str, bs:
+		if d, err := time.ParseDuration(v.String()); err == nil {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("%s isn't a duration", literal(v))
+}
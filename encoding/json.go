@@ -6,10 +6,11 @@
 package encoding
 
 import (
+	"bytes"
 	"encoding/json"
-	"reflect"
+	"errors"
+	"fmt"
 
-	"github.com/dev-mockingbird/logf"
 	"github.com/dev-mockingbird/ngin"
 )
 
@@ -18,33 +19,43 @@ func Init(ctx *ngin.Context) {
 	ctx.BindValuedFunc("encode-json", EncodeJson)
 	ctx.BindValuedFunc("decode-base64", decodeBase64)
 	ctx.BindValuedFunc("encode-base64", encodeBase64)
+	ctx.Describe("decode-json", "decode-json value\n\ndecodes any json value")
+	ctx.Describe("encode-json", "encode-json value\n\nencodes value as json")
+	ctx.Describe("decode-base64", "decode-base64 value\n\ndecodes standard base64")
+	ctx.Describe("encode-base64", "encode-base64 value\n\nencodes value as standard base64")
 }
 
+// DecodeJson decodes any json value: an object becomes a complex value
+// keeping the order of its attributes, an array a list, a number an int if
+// it's written without a fraction or an exponent and fits in 64 bits,
+// otherwise a float. It fails if the value isn't valid json.
 func DecodeJson(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
-	if len(args) == 0 {
-		ctx.Logger().Logf(logf.Error, "you should provide the args for json decode")
-		return ngin.Null{}
+	vs, err := ngin.EvalArgs(ctx, "decode-json", args, 1)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(vs[0].Bytes()))
+	dec.UseNumber()
+	ret, err := ngin.DecodeJSON(dec)
+	if err == nil && dec.More() {
+		err = errors.New("invalid data after the json value")
 	}
-	ret := make(map[string]any)
-	bs := args[0].WithContext(ctx).Bytes()
-	if err := json.Unmarshal(bs, &ret); err != nil {
-		ctx.Logger().Logf(logf.Error, "json unmarshal: %s", err.Error())
-		return ngin.Null{}
+	if err != nil {
+		return ngin.Fail(fmt.Errorf("decode-json: %w", err))
 	}
 	return ngin.ToValue(ret)
 }
 
+// EncodeJson encodes the value as json, see ngin.EncodeJSON. It fails if
+// the value can't be encoded, like a NaN float.
 func EncodeJson(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
-	if len(args) == 0 {
-		ctx.Logger().Logf(logf.Error, "you should provide the args for json encode")
-		return ngin.Null{}
+	vs, err := ngin.EvalArgs(ctx, "encode-json", args, 1)
+	if err != nil {
+		return ngin.Fail(err)
 	}
-	m := ngin.FromValue(args[0].WithContext(ctx))
-	mr := reflect.ValueOf(m)
-	bs, err := json.Marshal(mr.Addr().Interface())
+	bs, err := ngin.EncodeJSON(vs[0])
 	if err != nil {
-		ctx.Logger().Logf(logf.Error, "json marshal: %s", err.Error())
-		return ngin.Null{}
+		return ngin.Fail(fmt.Errorf("encode-json: %w", err))
 	}
 	return ngin.Bytes(bs)
 }
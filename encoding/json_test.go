@@ -0,0 +1,45 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package encoding_test
+
+import (
+	"testing"
+
+	"github.com/dev-mockingbird/ngin"
+	"github.com/dev-mockingbird/ngin/encoding"
+	"github.com/dev-mockingbird/ngin/ngintest"
+)
+
+func TestJson(t *testing.T) {
+	ctx, err := ngintest.Run(t, `
+user = decode-json `+"`"+`{"id":7,"tags":["a"]}`+"`"+`;
+body = encode-json user;
+`, encoding.Init, "user", "body")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := ctx.GetValue("body").String(); v != `{"id":7,"tags":["a"]}` {
+		t.Fatalf("unexpected body: %s", v)
+	}
+}
+
+func TestJson_Fail(t *testing.T) {
+	for src, expect := range map[string]string{
+		`none = decode-json;`:         "decode-json: too few arguments, got 0 at 1, 1",
+		`none = decode-json "{";`:     "decode-json: unexpected end of JSON input at 1, 1",
+		`none = decode-json "1 2";`:   "decode-json: invalid data after the json value at 1, 1",
+		`none = decode-json (1 / 0);`: "division by zero at 1, 1",
+		`none = encode-json;`:         "encode-json: too few arguments, got 0 at 1, 1",
+	} {
+		ctx, err := ngintest.Run(t, src, encoding.Init, "none")
+		if err == nil || err.Error() != expect {
+			t.Fatalf("%s: expect %s, got %v", src, expect, err)
+		}
+		if _, ok := ctx.GetValue("none").(ngin.Null); !ok {
+			t.Fatalf("%s: a failed value shouldn't be assigned", src)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	errDivisionByZero = errors.New("division by zero")
+	errOverflow       = errors.New("integer overflow")
+)
+
+type ArithOperator int
+
+const (
+	Add ArithOperator = iota
+	Sub
+	Mul
+	Div
+	Mod
+)
+
+var arithString = map[ArithOperator]string{
+	Add: "+",
+	Sub: "-",
+	Mul: "*",
+	Div: "/",
+	Mod: "%",
+}
+
+func (op ArithOperator) String() string {
+	return arithString[op]
+}
+
+// precedence is 2 for '*', '/' and '%', which bind tighter than '+' and
+// '-'
+func (op ArithOperator) precedence() int {
+	if op == Add || op == Sub {
+		return 1
+	}
+	return 2
+}
+
+// Expr is an arithmetic expression like `count + 1`, it's computed each
+// time its value is taken. The result is an int if both operands are ints,
+// otherwise a float. '+' adds ints and floats only, it joins the operands
+// as strings when one of them is something else, a string written as a
+// number included, so `"007" + 1` is `0071`. The other operators take
+// strings written as numbers too, the expression fails if they get
+// something else, if the divisor is zero or if the result of ints
+// overflows 64 bits, so does it if an operand fails. The expressions on
+// times and durations, like `now + 5m`, are computed by temporal.
+type Expr struct {
+	Operator    ArithOperator
+	Left, Right Value
+}
+
+func (e Expr) WithContext(ctx *Context) Value {
+	return Expr{Operator: e.Operator, Left: e.Left.WithContext(ctx), Right: e.Right.WithContext(ctx)}
+}
+
+func (e Expr) Value() Value {
+	left, right := e.Left.Value(), e.Right.Value()
+	if Err(left) != nil {
+		return left
+	}
+	if Err(right) != nil {
+		return right
+	}
+	if v, ok := temporal(e.Operator, left, right); ok {
+		return v
+	}
+	li, lf, lint, lok := number(left)
+	ri, rf, rint, rok := number(right)
+	if e.Operator == Add && (!numeric(left) || !numeric(right)) {
+		return String(left.String() + right.String())
+	}
+	if !lok || !rok {
+		operand := left
+		if lok {
+			operand = right
+		}
+		return Fail(fmt.Errorf("%s needs numbers, got %s", e.Operator.String(), literal(operand)))
+	}
+	if lint && rint {
+		switch e.Operator {
+		case Add:
+			return checked(li+ri, (li+ri > li) == (ri > 0))
+		case Sub:
+			return checked(li-ri, (li-ri < li) == (ri > 0))
+		case Mul:
+			r := li * ri
+			return checked(r, li == 0 || r/li == ri && !(li == -1 && ri == math.MinInt64))
+		case Div:
+			if ri == 0 {
+				return Fail(errDivisionByZero)
+			}
+			return checked(li/ri, li != math.MinInt64 || ri != -1)
+		default:
+			if ri == 0 {
+				return Fail(errDivisionByZero)
+			}
+			return Int(li % ri)
+		}
+	}
+	switch e.Operator {
+	case Add:
+		return Float(lf + rf)
+	case Sub:
+		return Float(lf - rf)
+	case Mul:
+		return Float(lf * rf)
+	case Div:
+		if rf == 0 {
+			return Fail(errDivisionByZero)
+		}
+		return Float(lf / rf)
+	default:
+		if rf == 0 {
+			return Fail(errDivisionByZero)
+		}
+		return Float(math.Mod(lf, rf))
+	}
+}
+
+// numeric reports whether v is an int or a float, not a string written as
+// a number
+func numeric(v Value) bool {
+	switch v.(type) {
+	case it, flt:
+		return true
+	}
+	return false
+}
+
+// checked returns the int i, or fails if computing it overflowed
+func checked(i int64, ok bool) Value {
+	if !ok {
+		return Fail(errOverflow)
+	}
+	return Int(i)
+}
+
+func (e Expr) Int() int64 {
+	return e.Value().Int()
+}
+
+func (e Expr) Float() float64 {
+	return e.Value().Float()
+}
+
+func (e Expr) String() string {
+	return e.Value().String()
+}
+
+func (e Expr) Bytes() []byte {
+	return e.Value().Bytes()
+}
+
+func (e Expr) Bool() bool {
+	return e.Value().Bool()
+}
+
+func (e Expr) Slice() []Value {
+	return []Value{e}
+}
+
+func (e Expr) Compare(v Value) int {
+	return e.Value().Compare(v)
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin
+
+import "fmt"
+
+// failure is the value of an operation which failed, like a division by
+// zero. It converts and compares like null, the statements getting it as
+// a value fail with its error.
+type failure struct {
+	err error
+}
+
+// Fail returns the value of an operation failed with err, a valued
+// function returns it to make the statement using its value fail
+func Fail(err error) Value {
+	return failure{err: err}
+}
+
+// Err returns the error of v if it's the value of a failed operation, nil
+// otherwise. v is expected to be evaluated already.
+func Err(v Value) error {
+	if f, ok := v.(failure); ok {
+		return f.err
+	}
+	return nil
+}
+
+// EvalArgs evaluates the args of the function name, which needs at least
+// min of them, it fails if an arg is the value of a failed operation. The
+// functions bound by the host use it to read their arguments.
+func EvalArgs(ctx *Context, name string, args []Value, min int) ([]Value, error) {
+	if len(args) < min {
+		return nil, fmt.Errorf("%s: too few arguments, got %d", name, len(args))
+	}
+	ret := make([]Value, len(args))
+	for i, arg := range args {
+		ret[i] = arg.WithContext(ctx).Value()
+		if err := Err(ret[i]); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// Condition makes f, a valued function returning a bool, a condition which
+// fails if f returns the value of a failed operation
+func Condition(f ValuedFunc) Func {
+	return func(ctx *Context, args ...Value) (bool, error) {
+		v := f(ctx, args...)
+		if err := Err(v); err != nil {
+			return false, err
+		}
+		return v.Bool(), nil
+	}
+}
+
+// ToInt converts v to an int like Int does, but it fails instead of
+// returning 0 if v isn't a number, an int or a float written as a string
+// included. The functions bound by the host use it to check their
+// arguments.
+func ToInt(v Value) (int64, error) {
+	i, f, isInt, err := toNumber(v)
+	if err != nil || isInt {
+		return i, err
+	}
+	return int64(f), nil
+}
+
+// ToFloat converts v to a float like Float does, but it fails instead of
+// returning 0 if v isn't a number
+func ToFloat(v Value) (float64, error) {
+	_, f, _, err := toNumber(v)
+	return f, err
+}
+
+func toNumber(v Value) (int64, float64, bool, error) {
+	v = v.Value()
+	if err := Err(v); err != nil {
+		return 0, 0, false, err
+	}
+	i, f, isInt, ok := number(v)
+	if !ok {
+		return 0, 0, false, fmt.Errorf("%s isn't a number", literal(v))
+	}
+	return i, f, isInt, nil
+}
+
+func (f failure) WithContext(*Context) Value {
+	return f
+}
+
+func (failure) Int() int64 {
+	return 0
+}
+
+func (failure) Float() float64 {
+	return 0
+}
+
+func (failure) String() string {
+	return ""
+}
+
+func (failure) Bytes() []byte {
+	return nil
+}
+
+func (failure) Bool() bool {
+	return false
+}
+
+func (f failure) Slice() []Value {
+	return []Value{f}
+}
+
+func (f failure) Compare(v Value) int {
+	return compare(f, v)
+}
+
+func (f failure) Value() Value {
+	return f
+}
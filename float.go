@@ -19,8 +19,8 @@ func (f flt) WithContext(*Context) Value {
 	return f
 }
 
-func (f flt) Int() uint64 {
-	return uint64(f.value)
+func (f flt) Int() int64 {
+	return int64(f.value)
 }
 
 func (f flt) Float() float64 {
@@ -28,7 +28,7 @@ func (f flt) Float() float64 {
 }
 
 func (f flt) String() string {
-	return strconv.FormatFloat(f.value, 'f', 0, 64)
+	return strconv.FormatFloat(f.value, 'f', -1, 64)
 }
 
 func (f flt) Bytes() []byte {
@@ -36,23 +36,15 @@ func (f flt) Bytes() []byte {
 }
 
 func (f flt) Bool() bool {
-	return f.Int() > 0
+	return f.value != 0
 }
 
 func (f flt) Compare(val Value) int {
-	i := f.Int()
-	j := val.Int()
-	if i > j {
-		return 1
-	} else if i == j {
-		return 0
-	}
-	return -1
+	return compare(f, val)
 }
 
 func (f flt) Slice() []Value {
 	return []Value{f}
-
 }
 
 func (f flt) Value() Value {
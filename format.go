@@ -0,0 +1,476 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+const indentation = "    "
+
+var operatorString = map[Operator]string{
+	EQ:      "==",
+	NEQ:     "!=",
+	GTE:     ">=",
+	GT:      ">",
+	LTE:     "<=",
+	LT:      "<",
+	Like:    "~",
+	NotLike: "!~",
+}
+
+// Format writes stmts back as canonical source: one statement per line,
+// blocks indented with 4 spaces, single spaces around operators and
+// strings quoted only when they have to. The comments are put back where
+// they were, and a single blank line is kept where there were some.
+func Format(w io.Writer, stmts []Stmt, comments []Comment) error {
+	f := formatter{comments: comments}
+	f.stmts(stmts, 0)
+	f.flush(math.MaxInt, 0)
+	_, err := w.Write(f.buf.Bytes())
+	return err
+}
+
+// FormatSource formats the script src, it fails if src has syntax errors.
+// The included files aren't read.
+func FormatSource(src []byte, file string) ([]byte, error) {
+	p := Parser{Lexer: NewLexer(), Reader: bytes.NewReader(src), File: file, SkipInclude: true}
+	stmts, err := p.Parse()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := Format(&buf, stmts, p.Comments()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type formatter struct {
+	buf      bytes.Buffer
+	comments []Comment
+	// lastRow is the last source row written, it tells where blank lines were
+	lastRow int
+	// open is set right after a '{', where blank lines are dropped
+	open  bool
+	depth int
+	// end is the closing '}' of the current block, comments after it
+	// belong to the block
+	end Pos
+}
+
+func (f *formatter) stmts(stmts []Stmt, depth int) {
+	for _, s := range stmts {
+		pos := stmtPos(s)
+		f.flush(pos.Row, depth)
+		f.blank(pos.Row)
+		f.indent(depth)
+		f.depth = depth
+		start := f.buf.Len()
+		f.stmt(s, depth)
+		last := stmtEnd(s)
+		switch s.(type) {
+		case MatchThenStmt, DefStmt, SwitchStmt, ForStmt:
+		default:
+			last = pos.Row + bytes.Count(f.buf.Bytes()[start:], []byte{'\n'})
+		}
+		f.trailing(last)
+		f.buf.WriteByte('\n')
+		f.lastRow = last
+	}
+}
+
+// flush writes the comments found before row
+func (f *formatter) flush(row, depth int) {
+	for len(f.comments) > 0 && f.comments[0].Pos.Row < row {
+		c := f.comments[0]
+		f.comments = f.comments[1:]
+		f.blank(c.Pos.Row)
+		f.indent(depth)
+		f.buf.WriteString("#" + strings.TrimRight(c.Text, " \t\r"))
+		f.buf.WriteByte('\n')
+		f.lastRow = c.Pos.Row
+	}
+}
+
+// trailing writes the comment found at the end of row
+func (f *formatter) trailing(row int) {
+	if len(f.comments) == 0 {
+		return
+	}
+	if c := f.comments[0].Pos; c.Row == row && (c.Row != f.end.Row || c.Col < f.end.Col) {
+		f.buf.WriteString(" #" + strings.TrimRight(f.comments[0].Text, " \t\r"))
+		f.comments = f.comments[1:]
+	}
+}
+
+func (f *formatter) blank(row int) {
+	if !f.open && f.lastRow > 0 && row > f.lastRow+1 {
+		f.buf.WriteByte('\n')
+	}
+	f.open = false
+}
+
+func (f *formatter) indent(depth int) {
+	for i := 0; i < depth; i++ {
+		f.buf.WriteString(indentation)
+	}
+}
+
+func (f *formatter) stmt(s Stmt, depth int) {
+	switch s := s.(type) {
+	case MatchThenStmt:
+		f.block(s, depth)
+	case DefStmt:
+		f.buf.WriteString(strings.Join(append([]string{"def", s.Name}, s.Params...), " ") + " ")
+		f.block(MatchThenStmt{Pos: s.Pos, End: s.End, Stmts: s.Stmts}, depth)
+	case SwitchStmt:
+		f.switchStmt(s, depth)
+	case ForStmt:
+		f.buf.WriteString("for ")
+		if s.Key != "" {
+			f.buf.WriteString(s.Key + ", ")
+		}
+		f.buf.WriteString(s.Item + " in " + f.value(s.In) + " ")
+		f.block(MatchThenStmt{Pos: s.Pos, End: s.End, Stmts: s.Stmts}, depth)
+	case BreakStmt:
+		f.buf.WriteString("break;")
+	case ContinueStmt:
+		f.buf.WriteString("continue;")
+	case AssignmentStmt:
+		f.buf.WriteString(s.Name + " = " + f.value(s.Value) + ";")
+	case ReturnStmt:
+		f.buf.WriteString(f.cond(s) + ";")
+	case IncludeStmt:
+		path := s.Path
+		if t, ok := lexOne(path); !ok || t.Type != TokenName && t.Type != TokenString {
+			path = quote(path)
+		}
+		f.buf.WriteString("include " + path + ";")
+	default:
+		f.buf.WriteString(f.cond(s) + ";")
+	}
+}
+
+func (f *formatter) block(mt MatchThenStmt, depth int) {
+	if _, ok := mt.Match.(EmptyStmt); !ok && mt.Match != nil {
+		f.buf.WriteString(f.cond(mt.Match) + " ")
+	}
+	f.buf.WriteString("{")
+	end := f.end
+	f.end = mt.End
+	defer func() { f.end = end }()
+	f.trailing(mt.Pos.Row)
+	f.lastRow = mt.Pos.Row
+	if len(mt.Stmts) > 0 || len(f.comments) > 0 && f.comments[0].Pos.Row < mt.End.Row {
+		f.buf.WriteByte('\n')
+		f.open = true
+		f.stmts(mt.Stmts, depth+1)
+		f.flush(mt.End.Row, depth+1)
+		f.open = false
+		f.indent(depth)
+		f.depth = depth
+	}
+	f.buf.WriteString("}")
+	f.lastRow = mt.End.Row
+	if e, ok := mt.Else.(MatchThenStmt); ok {
+		f.buf.WriteString(" else ")
+		f.block(e, depth)
+	}
+}
+
+func (f *formatter) switchStmt(s SwitchStmt, depth int) {
+	f.buf.WriteString("switch " + f.value(s.Subject) + " {")
+	end := f.end
+	f.end = s.End
+	defer func() { f.end = end }()
+	f.trailing(s.Pos.Row)
+	f.lastRow = s.Pos.Row
+	f.buf.WriteByte('\n')
+	f.open = true
+	for _, c := range s.Cases {
+		f.flush(c.Pos.Row, depth+1)
+		f.blank(c.Pos.Row)
+		f.indent(depth + 1)
+		f.depth = depth + 1
+		switch {
+		case c.Default:
+			f.buf.WriteString("default ")
+		case c.Operator == Like:
+			f.buf.WriteString("case ~ " + f.value(c.Value) + " ")
+		default:
+			f.buf.WriteString("case " + f.value(c.Value) + " ")
+		}
+		f.block(MatchThenStmt{Pos: c.Pos, End: c.End, Stmts: c.Stmts}, depth+1)
+		f.trailing(c.End.Row)
+		f.buf.WriteByte('\n')
+		f.lastRow = c.End.Row
+	}
+	f.flush(s.End.Row, depth+1)
+	f.open = false
+	f.indent(depth)
+	f.depth = depth
+	f.buf.WriteString("}")
+	f.lastRow = s.End.Row
+}
+
+func (f *formatter) cond(s Stmt) string {
+	switch s := s.(type) {
+	case MatchStmt:
+		return f.value(s.Left) + " " + operatorString[s.Operator] + " " + f.value(s.Right)
+	case FuncStmt:
+		return f.call(s.Name, s.Args)
+	case LogicStmt:
+		if s.Operator == Not && len(s.Stmts) == 1 {
+			switch operand := s.Stmts[0].(type) {
+			case MatchStmt:
+				return "!(" + f.cond(operand) + ")"
+			case LogicStmt:
+				if operand.Operator != Not {
+					return "!(" + f.cond(operand) + ")"
+				}
+			}
+			return "!" + f.cond(s.Stmts[0])
+		}
+		sep := " && "
+		if s.Operator == Or {
+			sep = " || "
+		}
+		operands := make([]string, len(s.Stmts))
+		for i, operand := range s.Stmts {
+			operands[i] = f.cond(operand)
+			if l, ok := operand.(LogicStmt); ok && l.Operator != Not && !(s.Operator == Or && l.Operator == And) {
+				operands[i] = "(" + operands[i] + ")"
+			}
+		}
+		return strings.Join(operands, sep)
+	case ReturnStmt:
+		if s.Value != nil {
+			return "return " + f.value(s.Value)
+		}
+		return "return"
+	case AssignmentStmt:
+		return s.Name + " = " + f.value(s.Value)
+	}
+	return ""
+}
+
+func (f *formatter) call(name string, args []Value) string {
+	ret := name
+	for _, arg := range args {
+		ret += " " + f.item(arg)
+	}
+	return ret
+}
+
+// item writes an argument or an item of a list, an expression is put in
+// parentheses, otherwise its operators would be taken for arguments
+func (f *formatter) item(v Value) string {
+	if _, ok := v.(Expr); ok {
+		return "(" + f.value(v) + ")"
+	}
+	return f.value(v)
+}
+
+// operand writes an operand of e, it's put in parentheses if it's an
+// expression which would be parsed apart otherwise
+func (f *formatter) operand(e Expr, v Value, right bool) string {
+	if o, ok := v.(Expr); ok {
+		p, q := o.Operator.precedence(), e.Operator.precedence()
+		if p < q || right && p == q {
+			return "(" + f.value(v) + ")"
+		}
+	}
+	return f.value(v)
+}
+
+func (f *formatter) value(v Value) string {
+	switch v := v.(type) {
+	case *Variable:
+		return f.call(v.Name, v.Args)
+	case Slice:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = f.item(item)
+		}
+		return strings.Join(items, " | ")
+	case Expr:
+		return f.operand(v, v.Left, false) + " " + v.Operator.String() + " " + f.operand(v, v.Right, true)
+	case Template:
+		ret := `"`
+		for _, part := range v.Parts {
+			if s, ok := part.(str); ok {
+				ret += escape(s.content)
+				continue
+			}
+			ret += "${" + f.value(part) + "}"
+		}
+		return ret + `"`
+	case Null:
+		return "null"
+	case bol, dur:
+		return v.String()
+	case nil:
+		return ""
+	}
+	return f.literal(v.String())
+}
+
+// literal writes s bare if it would be lexed back as a string, as a
+// heredoc if it holds several lines, otherwise quoted
+func (f *formatter) literal(s string) string {
+	if strings.Contains(s, "\n") && !strings.Contains(s, "\r") {
+		return f.heredoc(s)
+	}
+	if t, ok := lexOne(s); ok && (t.Type == TokenString || t.Type == TokenInt || t.Type == TokenFloat) &&
+		s[0] != '"' && s[0] != '`' && strings.Count(s, "(") == strings.Count(s, ")") &&
+		strings.IndexFunc(s, func(r rune) bool { return r <= ' ' || r == 0x7f }) < 0 {
+		return s
+	}
+	return quote(s)
+}
+
+func (f *formatter) heredoc(s string) string {
+	lines := strings.Split(s, "\n")
+	tag := "EOF"
+	for i := 1; ; i++ {
+		if !closesHeredoc(lines, tag) {
+			break
+		}
+		tag = fmt.Sprintf("EOF%d", i)
+	}
+	pad := strings.Repeat(indentation, f.depth+1)
+	var sb strings.Builder
+	sb.WriteString("<<" + tag + "\n")
+	for _, line := range lines {
+		if line != "" {
+			sb.WriteString(pad + line)
+		}
+		sb.WriteByte('\n')
+	}
+	sb.WriteString(pad + tag)
+	return sb.String()
+}
+
+func closesHeredoc(lines []string, tag string) bool {
+	for _, line := range lines {
+		line = strings.TrimLeft(line, " \t")
+		if !strings.HasPrefix(line, tag) {
+			continue
+		}
+		if rest := line[len(tag):]; rest == "" || !isNameByte(rest[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isNameByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func quote(s string) string {
+	return `"` + escape(s) + `"`
+}
+
+// escape escapes s to be written in a double quoted string
+func escape(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '$':
+			if i+1 < len(s) && s[i+1] == '{' {
+				sb.WriteByte('\\')
+			}
+			sb.WriteByte(c)
+		default:
+			if c < 0x20 || c == 0x7f {
+				sb.WriteString(fmt.Sprintf(`\u%04x`, c))
+				continue
+			}
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// lexOne lexes s, it reports whether s is exactly one token
+func lexOne(s string) (Token, bool) {
+	if s == "" {
+		return Token{}, false
+	}
+	l := NewLexer()
+	r := strings.NewReader(s)
+	t, err := l.Scan(r)
+	if err != nil || string(t.Raw) != s {
+		return t, false
+	}
+	next, err := l.Scan(r)
+	return t, err == nil && next.Type == TokenEOF
+}
+
+func stmtPos(s Stmt) Pos {
+	switch s := s.(type) {
+	case MatchThenStmt:
+		return s.Pos
+	case MatchStmt:
+		return s.Pos
+	case AssignmentStmt:
+		return s.Pos
+	case FuncStmt:
+		return s.Pos
+	case ReturnStmt:
+		return s.Pos
+	case LogicStmt:
+		return s.Pos
+	case IncludeStmt:
+		return s.Pos
+	case DefStmt:
+		return s.Pos
+	case SwitchStmt:
+		return s.Pos
+	case ForStmt:
+		return s.Pos
+	case BreakStmt:
+		return s.Pos
+	case ContinueStmt:
+		return s.Pos
+	}
+	return Pos{}
+}
+
+// stmtEnd returns the last row of a block statement
+func stmtEnd(s Stmt) int {
+	switch s := s.(type) {
+	case DefStmt:
+		return s.End.Row
+	case SwitchStmt:
+		return s.End.Row
+	case ForStmt:
+		return s.End.Row
+	}
+	mt, ok := s.(MatchThenStmt)
+	if !ok {
+		return stmtPos(s).Row
+	}
+	if mt.Else != nil {
+		return stmtEnd(mt.Else)
+	}
+	return mt.End.Row
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/dev-mockingbird/ngin"
+)
+
+func TestFormat(t *testing.T) {
+	src := `# gateway
+{
+	var header response host path ;
+  header.request-id==null{header.request-id = uuid;}   # set an id
+
+
+    listen 6000 {
+        host == "hello.com" | world.com && (path ~ ^/api || !(path == /)) {
+            backend 127.0.0.1:6090|127.0.0.1:6091;
+            response.body = "hello\t${header.name}, \"friend\"";
+            response.text = <<EOF
+                line 1
+                line 2
+                EOF;
+            # nothing else
+        } else path == "/ping" { response.code = 200; } else {
+            # keep me
+        }
+        return;
+    }
+}
+`
+	expect := `# gateway
+{
+    var header response host path;
+    header.request-id == null {
+        header.request-id = uuid;
+    } # set an id
+
+    listen 6000 {
+        host == "hello.com" | world.com && (path ~ ^/api || !(path == "/")) {
+            backend 127.0.0.1:6090 | 127.0.0.1:6091;
+            response.body = "hello\t${header.name}, \"friend\"";
+            response.text = <<EOF
+                line 1
+                line 2
+                EOF;
+            # nothing else
+        } else path == /ping {
+            response.code = 200;
+        } else {
+            # keep me
+        }
+        return;
+    }
+}
+`
+	got, err := ngin.FormatSource([]byte(src), "test.ngin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != expect {
+		t.Fatalf("unexpected format result:\n%s", got)
+	}
+	again, err := ngin.FormatSource(got, "test.ngin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, again) {
+		t.Fatalf("format isn't idempotent:\n%s", again)
+	}
+}
+
+func TestFormat_Def(t *testing.T) {
+	src := "def  auth token   {   # check it\n  token == null { return false; }\nreturn   token;}\ndef noop{}\n"
+	expect := `def auth token { # check it
+    token == null {
+        return false;
+    }
+    return token;
+}
+def noop {}
+`
+	got, err := ngin.FormatSource([]byte(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != expect {
+		t.Fatalf("unexpected result:\n%s", got)
+	}
+}
+
+func TestFormat_Switch(t *testing.T) {
+	src := `switch  method {   # by method
+case GET|HEAD { a = 1; }
+
+  # writes
+	case ~ ^P{}
+  default { b = 2; } # otherwise
+}
+`
+	expect := `switch method { # by method
+    case GET | HEAD {
+        a = 1;
+    }
+
+    # writes
+    case ~ ^P {}
+    default {
+        b = 2;
+    } # otherwise
+}
+`
+	got, err := ngin.FormatSource([]byte(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != expect {
+		t.Fatalf("unexpected result:\n%s", got)
+	}
+}
+
+func TestFormat_For(t *testing.T) {
+	src := "for k,v in query {last = v;  continue;}\nfor item  in a|b{break;}\n"
+	expect := `for k, v in query {
+    last = v;
+    continue;
+}
+for item in a | b {
+    break;
+}
+`
+	got, err := ngin.FormatSource([]byte(src), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != expect {
+		t.Fatalf("unexpected result:\n%s", got)
+	}
+}
+
+func TestFormat_Literals(t *testing.T) {
+	for _, s := range []string{
+		`a = "x y";`,
+		`a = "";`,
+		`a = "x;y";`,
+		`a = "(x";`,
+		`a = "\${x}";`,
+		`a = "null";`,
+		`a = "\u0001";`,
+		`a = 1.5;`,
+		`a = b c "d e";`,
+	} {
+		got, err := ngin.FormatSource([]byte(s), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != s+"\n" {
+			t.Fatalf("expect %s, got %s", s, got)
+		}
+	}
+}
+
+func TestFormat_Config(t *testing.T) {
+	for _, file := range []string{"config.ngin", "main/config.ngin"} {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewReader(src), File: file, SkipInclude: true}
+		stmts, err := p.Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := ngin.Format(&buf, stmts, p.Comments()); err != nil {
+			t.Fatal(err)
+		}
+		p = ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewReader(buf.Bytes()), File: file, SkipInclude: true}
+		again, err := p.Parse()
+		if err != nil {
+			t.Fatalf("formatted %s doesn't parse: %s", file, err)
+		}
+		if len(again) != len(stmts) {
+			t.Fatalf("formatted %s has %d statements, expect %d", file, len(again), len(stmts))
+		}
+	}
+}
+
+func TestFormat_Arith(t *testing.T) {
+	src := `a = (1 + 2)  *  3 - (b - c) - d / (e * f);
+f (a + 1) (b) | (c % 2);
+x = "${n + 1}";
+path == / {
+}
+t = now + 90s - 1h0m0s | "30s";
+`
+	expect := `a = (1 + 2) * 3 - (b - c) - d / (e * f);
+f (a + 1) b | (c % 2);
+x = "${n + 1}";
+path == "/" {}
+t = now + 1m30s - 1h | "30s";
+`
+	got, err := ngin.FormatSource([]byte(src), "arith.ngin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != expect {
+		t.Fatalf("unexpected format result:\n%s", got)
+	}
+}
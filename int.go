@@ -5,10 +5,10 @@ import (
 )
 
 type it struct {
-	value uint64
+	value int64
 }
 
-func Int(v uint64) Value {
+func Int(v int64) Value {
 	return it{value: v}
 }
 
@@ -16,12 +16,12 @@ func (it it) WithContext(*Context) Value {
 	return it
 }
 
-func (it it) Int() uint64 {
+func (it it) Int() int64 {
 	return it.value
 }
 
 func (it it) Bool() bool {
-	return it.value > 0
+	return it.value != 0
 }
 
 func (it it) Float() float64 {
@@ -33,18 +33,11 @@ func (it it) Bytes() []byte {
 }
 
 func (it it) String() string {
-	return strconv.FormatUint(it.value, 10)
+	return strconv.FormatInt(it.value, 10)
 }
 
 func (it it) Compare(val Value) int {
-	r := val.Int()
-	if it.value > r {
-		return 1
-	} else if it.value == r {
-		return 0
-	} else {
-		return -1
-	}
+	return compare(it, val)
 }
 
 func (it it) Slice() []Value {
@@ -0,0 +1,99 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// EncodeJSON encodes v as json, decoding the result with DecodeJSON then
+// converting it with ToValue gives v back: the attributes of a complex
+// value are in their order, a float is written with a decimal point to be
+// told from an int. Bytes which aren't valid utf-8 are written as a
+// base64 string, it's the only conversion which isn't reversed. It fails
+// if v is the value of a failed operation or a float json can't
+// represent, like NaN.
+func EncodeJSON(v Value) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeJSON(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeJSON(buf *bytes.Buffer, v Value) error {
+	switch v := v.Value().(type) {
+	case Null:
+		buf.WriteString("null")
+	case bol:
+		buf.WriteString(v.String())
+	case it:
+		buf.WriteString(v.String())
+	case flt:
+		if math.IsNaN(v.value) || math.IsInf(v.value, 0) {
+			return fmt.Errorf("json: unsupported float %s", v.String())
+		}
+		s := strconv.FormatFloat(v.value, 'g', -1, 64)
+		if !bytes.ContainsAny([]byte(s), ".e") {
+			s += ".0"
+		}
+		buf.WriteString(s)
+	case bs:
+		if utf8.Valid(v.content) {
+			return encodeJSONString(buf, string(v.content))
+		}
+		return encodeJSONString(buf, base64.StdEncoding.EncodeToString(v.content))
+	case Slice:
+		buf.WriteByte('[')
+		for i, item := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case *Complex:
+		buf.WriteByte('{')
+		for i, name := range v.names {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encodeJSONString(buf, name); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := encodeJSON(buf, v.attributes[name]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case failure:
+		return v.err
+	case nil:
+		return errors.New("json: nil value")
+	default:
+		return encodeJSONString(buf, v.String())
+	}
+	return nil
+}
+
+func encodeJSONString(buf *bytes.Buffer, s string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin_test
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/dev-mockingbird/ngin"
+)
+
+func decodeJSON(t *testing.T, s string) ngin.Value {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	v, err := ngin.DecodeJSON(dec)
+	if err != nil {
+		t.Fatalf("decode %s: %s", s, err.Error())
+	}
+	return ngin.ToValue(v)
+}
+
+func TestJSON_RoundTrip(t *testing.T) {
+	for _, s := range []string{
+		`null`,
+		`true`,
+		`false`,
+		`9007199254740993`,
+		`-9223372036854775808`,
+		`1.0`,
+		`1.5`,
+		`1e+21`,
+		`"hello"`,
+		`[]`,
+		`[1,"2",null,[true]]`,
+		`{}`,
+		`{"zeta":1,"alpha":{"b":2.0,"a":[]},"mid":null}`,
+	} {
+		bs, err := ngin.EncodeJSON(decodeJSON(t, s))
+		if err != nil {
+			t.Fatalf("encode %s: %s", s, err.Error())
+		}
+		if string(bs) != s {
+			t.Fatalf("expect %s, got %s", s, bs)
+		}
+	}
+}
+
+func TestJSON_Literals(t *testing.T) {
+	ctx := ngin.NewContext()
+	ctx.Declare("b")
+	if _, err := compile(t, `b.n = -1; b.m = 1; b.f = -1.5; b.zip = 007; b.big = 99999999999999999999; b.s = "2";`).Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expect := `{"n":-1,"m":1,"f":-1.5,"zip":"007","big":"99999999999999999999","s":"2"}`
+	bs, err := ngin.EncodeJSON(ctx.GetValue("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bs) != expect {
+		t.Fatalf("expect %s, got %s", expect, bs)
+	}
+	if bs, err = ngin.EncodeJSON(decodeJSON(t, string(bs))); err != nil || string(bs) != expect {
+		t.Fatalf("expect %s back, got %s, %v", expect, bs, err)
+	}
+}
+
+func TestJSON_Types(t *testing.T) {
+	v := decodeJSON(t, `{"id":9007199254740993,"price":2.0,"ok":false,"tags":["a"],"none":null}`)
+	c := v.(*ngin.Complex)
+	if id := c.AttrValue("id"); id.Int() != 9007199254740993 || id.String() != "9007199254740993" {
+		t.Fatalf("id: got %s", id.String())
+	}
+	if price := c.AttrValue("price"); price.String() != "2" || price.Float() != 2 {
+		t.Fatalf("price: got %s", price.String())
+	}
+	if ok := c.AttrValue("ok"); ok.String() != "false" {
+		t.Fatal("ok")
+	}
+	if tags, ok := c.AttrValue("tags").(ngin.Slice); !ok || len(tags) != 1 || tags[0].String() != "a" {
+		t.Fatal("tags")
+	}
+	if _, ok := c.AttrValue("none").(ngin.Null); !ok {
+		t.Fatal("none")
+	}
+}
+
+func TestEncodeJSON(t *testing.T) {
+	complex := ngin.NewComplex()
+	complex.SetAttr("b", ngin.Bytes([]byte("text")))
+	complex.SetAttr("a", ngin.Slice{ngin.Int(1), ngin.Float(2), ngin.Bool(true), ngin.Null{}})
+	for _, c := range []struct {
+		value  ngin.Value
+		expect string
+	}{
+		{complex, `{"b":"text","a":[1,2.0,true,null]}`},
+		{ngin.String("a\"b"), `"a\"b"`},
+		{ngin.Bytes([]byte{0xff, 0x00}), `"/wA="`},
+		{ngin.Float(-0.5), `-0.5`},
+	} {
+		bs, err := ngin.EncodeJSON(c.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(bs) != c.expect {
+			t.Fatalf("expect %s, got %s", c.expect, bs)
+		}
+	}
+	if _, err := ngin.EncodeJSON(ngin.Float(math.NaN())); err == nil {
+		t.Fatal("expect NaN to fail")
+	}
+}
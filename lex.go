@@ -5,6 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -29,17 +32,51 @@ const (
 	TokenStmtEnd    // ';'
 	TokenBlockBegin // '{'
 	TokenBlockEnd   // '}'
+	TokenNot        // '!'
+	TokenParenBegin // '('
+	TokenParenEnd   // ')'
+	TokenElse       // 'else'
+	TokenInclude    // 'include'
+	TokenDef        // 'def'
+	TokenSwitch     // 'switch'
+	TokenCase       // 'case'
+	TokenDefault    // 'default'
+	TokenFor        // 'for'
+	TokenIn         // 'in'
+	TokenBreak      // 'break'
+	TokenContinue   // 'continue'
+	TokenComma      // ','
+	TokenPlus       // '+'
+	TokenMinus      // '-'
+	TokenStar       // '*'
+	TokenSlash      // '/'
+	TokenPercent    // '%'
 	TokenName       // ''
 	TokenFloat      // ''
+	TokenDuration   // '30s', '1h30m'
 	TokenComment    // '# xxxx\n'
 	TokenInt
 	TokenBool
 	TokenString
+	TokenTemplate // '"xxx ${expr} xxx"'
 	TokenEOF
 )
 
 var tokenMap map[int]string
 
+// keywords are names which are lexed as their own token type
+var keywords map[string]int
+
+// arithTokens are the chars lexed as arithmetic operators when they stand
+// alone
+var arithTokens = map[byte]int{
+	'+': TokenPlus,
+	'-': TokenMinus,
+	'*': TokenStar,
+	'/': TokenSlash,
+	'%': TokenPercent,
+}
+
 func init() {
 	tokenMap = map[int]string{
 		TokenNull:       "null",
@@ -61,49 +98,83 @@ func init() {
 		TokenStmtEnd:    ";",
 		TokenBlockBegin: "{",
 		TokenBlockEnd:   "}",
+		TokenNot:        "!",
+		TokenParenBegin: "(",
+		TokenParenEnd:   ")",
+		TokenComma:      ",",
+		TokenPlus:       "+",
+		TokenMinus:      "-",
+		TokenStar:       "*",
+		TokenSlash:      "/",
+		TokenPercent:    "%",
+		TokenEOF:        "EOF",
+	}
+	keywords = map[string]int{
+		"null":     TokenNull,
+		"return":   TokenReturn,
+		"true":     TokenTrue,
+		"false":    TokenFalse,
+		"else":     TokenElse,
+		"include":  TokenInclude,
+		"def":      TokenDef,
+		"switch":   TokenSwitch,
+		"case":     TokenCase,
+		"default":  TokenDefault,
+		"for":      TokenFor,
+		"in":       TokenIn,
+		"break":    TokenBreak,
+		"continue": TokenContinue,
 	}
 }
 
 const (
 	stateStart = iota
-	stateNull
-	stateReturn
-	stateEqual
-	stateGTE
+	stateAssignment
 	stateGT
-	stateLTE
 	stateLT
-	stateAssignment
+	stateSep
+	stateAmp
 	stateComment
 	stateNot
+	stateArith
 	stateName
 	stateNumber
 	stateFloat
+	stateDuration
 	stateString
+	stateBareString
+	stateBareAmp
+	stateBareBrace
+	stateEscape
+	stateUnicode
+	stateRawString
+	stateHeredocTag
+	stateHeredoc
+	stateDollar
+	stateInterpolation
 	stateEnd
-	stateTrue
-	stateFalse
-)
-
-var (
-	null = []byte{'n', 'u', 'l', 'l'}
-	fls  = []byte{'f', 'a', 'l', 's', 'e'}
-	tru  = []byte{'t', 'r', 'u', 'e'}
-	rtrn = []byte{'r', 'e', 't', 'u', 'r', 'n'}
 )
 
 type UnexpectedChar byte
 
 type PosError struct {
-	Row int
-	Col int
-	err error
+	File string
+	Row  int
+	Col  int
+	err  error
 }
 
 func (e PosError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("%s at %s:%d:%d", e.err.Error(), e.File, e.Row, e.Col)
+	}
 	return fmt.Sprintf("%s at %d, %d", e.err.Error(), e.Row, e.Col)
 }
 
+func (e PosError) Unwrap() error {
+	return e.err
+}
+
 func (c UnexpectedChar) Error() string {
 	return fmt.Sprintf("unexpected char '%s'", []byte{byte(c)})
 }
@@ -113,21 +184,98 @@ type Token struct {
 	Row  int
 	Col  int
 	Raw  []byte
+	// Parts are the literals and the ${expr} sources of a TokenTemplate
+	Parts []TemplatePart
+}
+
+// TemplatePart is a piece of an interpolated string, Row and Col tell
+// where an expression begins
+type TemplatePart struct {
+	Expr bool
+	Raw  []byte
+	Row  int
+	Col  int
 }
 
 func (t Token) String() string {
 	if s, ok := tokenMap[t.Type]; ok {
 		return s
 	}
+	if t.Type == TokenTemplate {
+		ret := []byte{'"'}
+		for _, part := range t.Parts {
+			if part.Expr {
+				ret = append(append(append(ret, '$', '{'), part.Raw...), '}')
+				continue
+			}
+			ret = append(ret, part.Raw...)
+		}
+		return string(append(ret, '"'))
+	}
 	return string(t.Raw)
 }
 
+// Lexer splits a script into tokens. A token ends as soon as the next
+// character can't belong to it, so whitespace is only needed where two
+// tokens of the same class would otherwise run together, e.g. `a==b`,
+// `host==x|y` and `a{` are lexed the same way as their spaced forms.
+//
+// Names (`header.request-id`), numbers, durations (`30s`, `1h30m`) and
+// keywords end at whitespace, at a quote or at any of
+// `; { } | = ! < > ~ & ( ) + * %`. A name or number followed by any other
+// character becomes an unquoted string, which only ends at whitespace, at
+// one of `; { } |`, at `&&` or at a `)` which closes no `(` of the string
+// itself, so `127.0.0.1:6090`, `/idinfo/*` and `/(v1)` stay in one piece.
+// A `|` inside a `(` of the string and a regex repetition like `{3}` or
+// `{2,5}` glued to a name or a string belong to it too, so the regexes
+// `/api/(v1|v2)/.*`, `\d{3}` and `a{2,3}` needn't be quoted.
+//
+// So `+`, `*` and `%` are operators right after a name, a number or a
+// `)`, or right before an operand: `count+1`, `count *2` and `(1 + 2)*3`
+// are expressions, while `/a+b` or `*.example.com` stay strings. `-` and
+// `/` belong to names and unquoted strings like `request-id` or
+// `text/html`, they're operators only after a whitespace or a `)`, like in
+// `count - 1` or `(a)/2`.
+//
+// Strings can also be written as
+//
+//	"double quoted", supporting the escapes \" \\ \n \r \t \$ and \uXXXX,
+//	    and ${expr} which is interpolated when the string is evaluated
+//	`raw`, kept as it is, which is handy for regexes
+//	<<EOF
+//	    multi-line text, ended by a line holding the delimiter only,
+//	    whose indentation is removed from every line
+//	    EOF
 type Lexer struct {
-	b     []byte
-	state int
-	stash []byte
-	col   int
-	row   int
+	b          []byte
+	state      int
+	stash      []byte
+	col        int
+	row        int
+	pushedBack bool
+	// err is the error met inside a quoted string, it's reported once the
+	// string is over so that the rest of the string isn't lexed as tokens
+	err error
+	// hex collects the digits of a \uXXXX escape
+	hex []byte
+	// depth, quote and escaped track the nesting of the ${expr} being
+	// scanned, so that a '}' or a '"' inside it doesn't end it too early
+	depth   int
+	quote   byte
+	escaped bool
+	// tag is the delimiter of the heredoc being scanned, and lineStart
+	// is where its current line begins in the token
+	tag       []byte
+	lineStart int
+	// operand is set when the last token is a name, a number, a duration
+	// or a ')' and no whitespace followed it yet, an arithmetic char met
+	// then is an operator, like in `count+1` or `(a)/2`
+	operand bool
+	// brace collects what follows a '{' glued to a name or an unquoted
+	// string, which are kept together if it's a regex repetition like
+	// `{3}` or `{2,5}`, braceType is the type of the token otherwise
+	brace     []byte
+	braceType int
 }
 
 func NewLexer() *Lexer {
@@ -138,425 +286,677 @@ func (l *Lexer) Scan(r io.Reader) (t Token, err error) {
 	if l.b == nil {
 		l.b = make([]byte, 1)
 	}
-	t.Row, t.Col = l.resolvePosition()
+	if l.row == 0 {
+		l.row, l.col = 1, 1
+	}
 	for {
+		eof := false
 		if len(l.stash) > 0 {
 			l.b[0] = l.stash[0]
 			l.stash = l.stash[1:]
 		} else {
 			_, err = r.Read(l.b)
 			if err != nil {
-				if errors.Is(err, io.EOF) {
-					err = nil
-					t.Type = TokenEOF
+				if !errors.Is(err, io.EOF) {
+					return
+				}
+				err = nil
+				if eof, err = l.finish(&t); eof || err != nil {
 					return
 				}
-				return
 			}
 		}
+		l.pushedBack = false
+		state := l.state
 		switch l.state {
 		case stateStart:
 			err = l.stateStart(&t)
-		case stateNull:
-			err = l.stateNull(&t)
-		case stateReturn:
-			err = l.stateReturn(&t)
-		case stateFalse:
-			err = l.stateFalse(&t)
-		case stateTrue:
-			err = l.stateTrue(&t)
 		case stateComment:
 			err = l.stateComment(&t)
 		case stateName:
 			err = l.stateName(&t)
 		case stateString:
 			err = l.stateString(&t)
+		case stateEscape:
+			err = l.stateEscape(&t)
+		case stateUnicode:
+			err = l.stateUnicode(&t)
+		case stateRawString:
+			err = l.stateRawString(&t)
+		case stateHeredocTag:
+			err = l.stateHeredocTag(&t)
+		case stateHeredoc:
+			err = l.stateHeredoc(&t)
+		case stateDollar:
+			err = l.stateDollar(&t)
+		case stateInterpolation:
+			err = l.stateInterpolation(&t)
+		case stateBareString:
+			err = l.stateBareString(&t)
+		case stateBareAmp:
+			err = l.stateBareAmp(&t)
+		case stateBareBrace:
+			err = l.stateBareBrace(&t)
 		case stateNumber:
 			err = l.stateNumber(&t)
 		case stateFloat:
 			err = l.stateFloat(&t)
+		case stateDuration:
+			err = l.stateDuration(&t)
 		case stateNot:
 			err = l.stateNot(&t)
-		case stateEqual:
-			err = l.stateEqual(&t)
+		case stateArith:
+			err = l.stateArith(&t)
 		case stateAssignment:
 			err = l.stateAssignment(&t)
 		case stateGT:
 			err = l.stateGT(&t)
-		case stateGTE:
-			err = l.stateGTE(&t)
 		case stateLT:
 			err = l.stateLT(&t)
-		case stateLTE:
-			err = l.stateLTE(&t)
+		case stateSep:
+			err = l.stateSep(&t)
+		case stateAmp:
+			err = l.stateAmp(&t)
+		}
+		if err != nil {
+			err = PosError{err: err, Row: l.row, Col: l.col}
+			switch state {
+			case stateEscape, stateUnicode, stateInterpolation:
+				if l.err == nil {
+					l.err = err
+				}
+				l.state, err = stateString, nil
+			default:
+				l.state = stateStart
+				return
+			}
+		}
+		if !l.pushedBack && !eof {
+			l.advance()
 		}
 		if l.state == stateEnd {
 			l.state = stateStart
+			switch t.Type {
+			case TokenName, TokenInt, TokenFloat, TokenDuration, TokenParenEnd:
+				l.operand = true
+			default:
+				l.operand = false
+			}
+			if err, l.err = l.err, nil; err != nil {
+				t.Type = TokenEmpty
+			}
 			return
 		}
-		if err != nil {
-			row, col := l.resolvePosition()
-			err = PosError{err: err, Row: row, Col: col}
-			return
-		}
-		if l.b[0] == '\n' {
-			l.col = 1
-			l.row++
-			continue
+	}
+}
+
+// finish is called when the reader is drained. It reports whether the
+// EOF token should be returned, otherwise it feeds a trailing whitespace
+// so that the pending token is terminated like any other.
+func (l *Lexer) finish(t *Token) (bool, error) {
+	if l.err != nil {
+		err := l.err
+		l.err, l.state = nil, stateStart
+		return true, err
+	}
+	switch l.state {
+	case stateStart:
+		t.Type = TokenEOF
+		t.Row, t.Col = l.row, l.col
+		return true, nil
+	case stateComment:
+		t.Type = TokenComment
+		l.state = stateStart
+		return true, nil
+	case stateHeredoc:
+		if bytes.Equal(bytes.TrimLeft(t.Raw[l.lineStart:], " \t"), l.tag) {
+			break
 		}
-		l.col++
+		fallthrough
+	case stateString, stateEscape, stateUnicode, stateRawString, stateHeredocTag, stateDollar, stateInterpolation:
+		l.state = stateStart
+		return true, PosError{err: errors.New("unterminated string"), Row: t.Row, Col: t.Col}
 	}
+	l.b[0] = ' '
+	return false, nil
 }
 
-func (l *Lexer) resolvePosition() (int, int) {
-	col := l.col
-	row := l.row - bytes.Count(l.stash, []byte{'\n'})
-	if row != l.row {
-		col = len(l.stash[bytes.LastIndex(l.stash, []byte{'\n'}):])
+func (l *Lexer) advance() {
+	if l.b[0] == '\n' {
+		l.col = 1
+		l.row++
+		return
 	}
-	return row, col
+	l.col++
 }
 
-func (l *Lexer) stateNumber(t *Token) error {
-	switch {
-	case l.b[0] == '.':
-		t.Raw = append(t.Raw, '.')
-		l.state = stateFloat
-	case l.isNumber():
-		t.Raw = append(t.Raw, l.b[0])
-	case l.isWhitespace():
-		t.Type = TokenInt
-		l.state = stateEnd
-	case l.isSep():
-		t.Type = TokenInt
-		l.state = stateEnd
-		l.stash = []byte{l.b[0]}
-	default:
-		l.state = stateString
-		t.Raw = append(t.Raw, l.b[0])
+// unread pushes the current char back, it will be scanned again as the
+// beginning of the next token
+func (l *Lexer) unread() {
+	l.stash = append([]byte{l.b[0]}, l.stash...)
+	l.pushedBack = true
+}
+
+// end terminates the current token, the current char is kept for the next
+// token if unread is true
+func (l *Lexer) end(t *Token, typ int, unread bool) {
+	t.Type = typ
+	l.state = stateEnd
+	if unread {
+		l.unread()
 	}
-	return nil
 }
 
-func (l *Lexer) stateFloat(t *Token) error {
-	switch {
-	case l.isNumber():
+func (l *Lexer) stateStart(t *Token) error {
+	if l.isWhitespace() {
+		l.operand = false
+		return nil
+	}
+	t.Row, t.Col = l.row, l.col
+	switch l.b[0] {
+	case '{':
+		l.end(t, TokenBlockBegin, false)
+	case '}':
+		l.end(t, TokenBlockEnd, false)
+	case '|':
+		l.state = stateSep
+	case '&':
+		l.state = stateAmp
+	case '(':
+		l.end(t, TokenParenBegin, false)
+	case ')':
+		l.end(t, TokenParenEnd, false)
+	case ';':
+		l.end(t, TokenStmtEnd, false)
+	case ',':
+		l.end(t, TokenComma, false)
+	case '~':
+		l.end(t, TokenLike, false)
+	case '!':
+		l.state = stateNot
+	case '=':
+		l.state = stateAssignment
+	case '>':
+		l.state = stateGT
+	case '<':
+		l.state = stateLT
+	case '#':
+		l.state = stateComment
+	case '"':
+		l.state = stateString
+	case '`':
+		l.state = stateRawString
+	case '+', '-', '*', '/', '%':
+		if l.operand {
+			l.end(t, arithTokens[l.b[0]], false)
+			break
+		}
 		t.Raw = append(t.Raw, l.b[0])
-	case l.isWhitespace():
-		t.Type = TokenFloat
-		l.state = stateEnd
-	case l.isSep():
-		t.Type = TokenFloat
-		l.state = stateEnd
-		l.stash = []byte{l.b[0]}
+		l.state = stateArith
 	default:
-		l.state = stateString
 		t.Raw = append(t.Raw, l.b[0])
+		switch {
+		case l.isAlpha() || l.b[0] == '_' || l.b[0] == '-':
+			l.state = stateName
+		case l.isNumber():
+			l.state = stateNumber
+		default:
+			l.state = stateBareString
+		}
 	}
 	return nil
 }
 
 func (l *Lexer) stateComment(t *Token) error {
 	if l.b[0] == '\n' {
-		t.Type = TokenComment
-		l.state = stateEnd
+		l.end(t, TokenComment, false)
 		return nil
 	}
 	t.Raw = append(t.Raw, l.b[0])
 	return nil
 }
 
-func (l *Lexer) stateEqual(t *Token) error {
+// stateArith decides whether the char met at the beginning of a token is
+// an arithmetic operator or begins a number like `-1`, a name like `-x` or
+// a bare string like `/api`. It's an operator if it's followed by a whitespace, `+`, `*` and
+// `%` are also operators if they're followed by what begins an operand,
+// like in `count *2`.
+func (l *Lexer) stateArith(t *Token) error {
+	if l.isWhitespace() || isGlued(t.Raw[0]) && l.beginsOperand() {
+		typ := arithTokens[t.Raw[0]]
+		t.Raw = t.Raw[:0]
+		l.end(t, typ, true)
+		return nil
+	}
 	switch {
-	case l.isWhitespace():
-		t.Type = TokenEQ
-		l.state = stateEnd
+	case t.Raw[0] == '-' && l.isNumber():
+		l.state = stateNumber
+	case t.Raw[0] == '-':
+		l.state = stateName
 	default:
-		l.state = stateString
-		l.stash = []byte{l.b[0]}
+		l.state = stateBareString
 	}
+	l.unread()
 	return nil
 }
 
-func (l *Lexer) stateNull(t *Token) error {
+func (l *Lexer) stateName(t *Token) error {
 	switch {
-	case len(t.Raw) < 4 && null[len(t.Raw)] == l.b[0]:
+	case l.isName():
 		t.Raw = append(t.Raw, l.b[0])
-		return nil
-	case l.isWhitespace():
-		t.Type = TokenNull
-		l.state = stateEnd
-		return nil
-	case l.isSep():
-		t.Type = TokenNull
-		l.state = stateEnd
-		l.stash = []byte{l.b[0]}
-		return nil
+	case l.b[0] == '{':
+		typ, ok := keywords[string(t.Raw)]
+		if !ok {
+			typ = TokenName
+		}
+		l.brace, l.braceType = append(l.brace[:0], '{'), typ
+		l.state = stateBareBrace
+	case l.isDelimiter() || l.b[0] == ',' || isGlued(l.b[0]):
+		typ, ok := keywords[string(t.Raw)]
+		if !ok {
+			typ = TokenName
+		}
+		l.end(t, typ, true)
 	default:
-		l.state = stateName
-		return nil
+		t.Raw = append(t.Raw, l.b[0])
+		l.state = stateBareString
 	}
+	return nil
 }
 
-func (l *Lexer) stateTrue(t *Token) error {
+func (l *Lexer) stateNumber(t *Token) error {
 	switch {
-	case len(t.Raw) < 4 && tru[len(t.Raw)] == l.b[0]:
+	case l.isNumber():
 		t.Raw = append(t.Raw, l.b[0])
-		return nil
-	case l.isWhitespace():
-		t.Type = TokenTrue
-		l.state = stateEnd
-		return nil
-	case l.isSep():
-		t.Type = TokenTrue
-		l.state = stateEnd
-		l.stash = []byte{l.b[0]}
-		return nil
+	case l.b[0] == '.':
+		t.Raw = append(t.Raw, l.b[0])
+		l.state = stateFloat
+	case l.isUnit():
+		t.Raw = append(t.Raw, l.b[0])
+		l.state = stateDuration
+	case l.isDelimiter() || isGlued(l.b[0]):
+		l.end(t, TokenInt, true)
 	default:
-		l.state = stateName
 		t.Raw = append(t.Raw, l.b[0])
-		return nil
+		l.state = stateBareString
 	}
+	return nil
 }
 
-func (l *Lexer) stateFalse(t *Token) error {
+func (l *Lexer) stateFloat(t *Token) error {
 	switch {
-	case len(t.Raw) < 4 && fls[len(t.Raw)] == l.b[0]:
+	case l.isNumber():
 		t.Raw = append(t.Raw, l.b[0])
-		return nil
-	case l.isWhitespace():
-		t.Type = TokenFalse
-		l.state = stateEnd
-		return nil
-	case l.isSep():
-		t.Type = TokenFalse
-		l.state = stateEnd
-		l.stash = []byte{l.b[0]}
-		return nil
+	case l.isUnit():
+		t.Raw = append(t.Raw, l.b[0])
+		l.state = stateDuration
+	case l.isDelimiter() || isGlued(l.b[0]):
+		l.end(t, TokenFloat, true)
 	default:
 		t.Raw = append(t.Raw, l.b[0])
-		l.state = stateName
-		return nil
+		l.state = stateBareString
 	}
+	return nil
 }
 
-func (l *Lexer) stateReturn(t *Token) error {
+// stateDuration scans a number followed by a unit, like `30s` or `1h30m`,
+// it's a duration if time.ParseDuration accepts it, otherwise an unquoted
+// string like `5min` or `3h2`
+func (l *Lexer) stateDuration(t *Token) error {
 	switch {
-	case len(t.Raw) < 6 && rtrn[len(t.Raw)] == l.b[0]:
+	case l.isNumber() || l.isUnit() || l.b[0] == '.':
 		t.Raw = append(t.Raw, l.b[0])
-	case l.isWhitespace():
-		t.Type = TokenReturn
-		l.state = stateEnd
-	case l.isSep():
-		t.Type = TokenReturn
-		l.state = stateEnd
-		l.stash = []byte{l.b[0]}
+	case l.isDelimiter() || isGlued(l.b[0]):
+		if _, err := time.ParseDuration(string(t.Raw)); err != nil {
+			l.end(t, TokenString, true)
+			break
+		}
+		l.end(t, TokenDuration, true)
 	default:
-		l.state = stateName
 		t.Raw = append(t.Raw, l.b[0])
-		return nil
+		l.state = stateBareString
 	}
 	return nil
 }
 
-func (l *Lexer) stateNot(t *Token) error {
-	if l.isWhitespace() {
-		return nil
+func (l *Lexer) stateString(t *Token) error {
+	switch l.b[0] {
+	case '"':
+		if len(t.Parts) == 0 {
+			l.end(t, TokenString, false)
+			break
+		}
+		if len(t.Raw) > 0 {
+			t.Parts = append(t.Parts, TemplatePart{Raw: t.Raw})
+		}
+		t.Raw = nil
+		l.end(t, TokenTemplate, false)
+	case '\\':
+		l.state = stateEscape
+	case '$':
+		l.state = stateDollar
+	default:
+		t.Raw = append(t.Raw, l.b[0])
 	}
+	return nil
+}
+
+func (l *Lexer) stateEscape(t *Token) error {
+	l.state = stateString
 	switch l.b[0] {
-	case '=':
-		t.Type = TokenNEQ
-		l.state = stateEnd
-	case '~':
-		t.Type = TokenNotLike
-		l.state = stateEnd
+	case '"', '\\', '$':
+		t.Raw = append(t.Raw, l.b[0])
+	case 'n':
+		t.Raw = append(t.Raw, '\n')
+	case 'r':
+		t.Raw = append(t.Raw, '\r')
+	case 't':
+		t.Raw = append(t.Raw, '\t')
+	case 'u':
+		l.hex = l.hex[:0]
+		l.state = stateUnicode
 	default:
-		t.Type = TokenString
-		t.Raw = []byte{'!', l.b[0]}
+		return fmt.Errorf("unknown escape sequence '\\%c'", l.b[0])
 	}
 	return nil
 }
 
-func (l *Lexer) stateAssignment(t *Token) error {
-	if l.isWhitespace() {
-		t.Type = TokenAssignment
+func (l *Lexer) stateUnicode(t *Token) error {
+	if !l.isHex() {
+		return fmt.Errorf("invalid unicode escape '\\u%s%c'", l.hex, l.b[0])
+	}
+	l.hex = append(l.hex, l.b[0])
+	if len(l.hex) < 4 {
 		return nil
 	}
-	switch {
-	case l.b[0] == '=':
-		l.state = stateEqual
-	default:
-		t.Type = TokenAssignment
-		l.stash = []byte{l.b[0]}
-		l.state = stateEnd
+	r, err := strconv.ParseUint(string(l.hex), 16, 32)
+	if err != nil {
+		return err
 	}
+	t.Raw = utf8.AppendRune(t.Raw, rune(r))
+	l.state = stateString
 	return nil
 }
 
-func (l *Lexer) stateName(t *Token) error {
-	switch {
-	case l.isName():
-		t.Raw = append(t.Raw, l.b[0])
-		return nil
-	case l.isWhitespace():
-		t.Type = TokenName
-		l.state = stateEnd
-	case l.isSep():
-		t.Type = TokenName
-		l.state = stateEnd
-		l.stash = []byte{l.b[0]}
-	default:
+func (l *Lexer) stateDollar(t *Token) error {
+	if l.b[0] != '{' {
+		t.Raw = append(t.Raw, '$')
 		l.state = stateString
-		t.Raw = append(t.Raw, l.b[0])
+		l.unread()
+		return nil
+	}
+	if len(t.Raw) > 0 {
+		t.Parts = append(t.Parts, TemplatePart{Raw: t.Raw})
 	}
+	t.Raw = nil
+	t.Parts = append(t.Parts, TemplatePart{Expr: true, Row: l.row, Col: l.col + 1})
+	l.depth, l.quote, l.escaped = 0, 0, false
+	l.state = stateInterpolation
 	return nil
 }
 
-func (l *Lexer) stateString(t *Token) error {
+func (l *Lexer) stateInterpolation(t *Token) error {
+	part := &t.Parts[len(t.Parts)-1]
 	switch {
-	case l.b[0] == '"' && t.Raw[0] == '"':
-		t.Type = TokenString
-		t.Raw = t.Raw[1:]
-		l.state = stateEnd
-	case l.b[0] == ';':
-		t.Type = TokenString
-		l.state = stateEnd
-		l.stash = []byte{l.b[0]}
-	case l.isWhitespace():
-		t.Type = TokenString
-		l.state = stateEnd
-	default:
-		t.Raw = append(t.Raw, l.b[0])
+	case l.escaped:
+		l.escaped = false
+	case l.quote != 0:
+		if l.b[0] == '\\' && l.quote == '"' {
+			l.escaped = true
+		} else if l.b[0] == l.quote {
+			l.quote = 0
+		}
+	case l.b[0] == '"' || l.b[0] == '`':
+		l.quote = l.b[0]
+	case l.b[0] == '{':
+		l.depth++
+	case l.b[0] == '}':
+		if l.depth == 0 {
+			if len(bytes.TrimSpace(part.Raw)) == 0 {
+				return errors.New("empty interpolation")
+			}
+			l.state = stateString
+			return nil
+		}
+		l.depth--
 	}
+	part.Raw = append(part.Raw, l.b[0])
 	return nil
 }
 
-func (l *Lexer) stateLTE(t *Token) error {
-	switch {
-	case l.isWhitespace():
-		t.Type = TokenGTE
-		l.state = stateEnd
-	default:
-		l.state = stateString
-		t.Raw = []byte{'<', '=', l.b[0]}
+func (l *Lexer) stateRawString(t *Token) error {
+	if l.b[0] == '`' {
+		l.end(t, TokenString, false)
+		return nil
 	}
+	t.Raw = append(t.Raw, l.b[0])
 	return nil
 }
 
-func (l *Lexer) stateLT(t *Token) error {
+func (l *Lexer) stateHeredocTag(t *Token) error {
 	switch {
-	case l.b[0] == '=':
-		l.state = stateLTE
-	case l.isWhitespace():
-		l.state = stateEnd
-		t.Type = TokenLT
+	case l.isName() && l.b[0] != '.':
+		l.tag = append(l.tag, l.b[0])
+	case l.b[0] == '\n':
+		if len(l.tag) == 0 {
+			return errors.New("heredoc requires a delimiter after '<<'")
+		}
+		l.lineStart = 0
+		l.state = stateHeredoc
+	case l.b[0] == ' ' || l.b[0] == '\t' || l.b[0] == '\r':
 	default:
-		l.state = stateString
-		t.Raw = []byte{'<', l.b[0]}
+		return UnexpectedChar(l.b[0])
 	}
 	return nil
 }
 
-func (l *Lexer) stateGTE(t *Token) error {
-	switch {
-	case l.isWhitespace():
-		t.Type = TokenGTE
-		l.state = stateEnd
-	default:
-		l.state = stateString
-		t.Raw = []byte{'>', '=', l.b[0]}
+func (l *Lexer) stateHeredoc(t *Token) error {
+	line := bytes.TrimLeft(t.Raw[l.lineStart:], " \t")
+	if bytes.Equal(line, l.tag) && !(l.isName() && l.b[0] != '.') {
+		indent := t.Raw[l.lineStart : len(t.Raw)-len(line)]
+		lines := bytes.Split(bytes.TrimSuffix(t.Raw[:l.lineStart], []byte{'\n'}), []byte{'\n'})
+		for i, s := range lines {
+			lines[i] = bytes.TrimSuffix(bytes.TrimPrefix(s, indent), []byte{'\r'})
+		}
+		t.Raw = bytes.Join(lines, []byte{'\n'})
+		l.tag = l.tag[:0]
+		l.end(t, TokenString, true)
+		return nil
+	}
+	t.Raw = append(t.Raw, l.b[0])
+	if l.b[0] == '\n' {
+		l.lineStart = len(t.Raw)
 	}
 	return nil
 }
 
-func (l *Lexer) stateGT(t *Token) error {
+func (l *Lexer) stateBareString(t *Token) error {
 	switch {
-	case l.b[0] == '=':
-		l.state = stateGTE
-	case l.isWhitespace():
-		t.Type = TokenGT
-		l.state = stateEnd
+	case l.b[0] == '|' && l.inGroup(t):
+		t.Raw = append(t.Raw, l.b[0])
+	case l.b[0] == '{':
+		l.brace, l.braceType = append(l.brace[:0], '{'), TokenString
+		l.state = stateBareBrace
+	case l.isWhitespace() || l.isPunct():
+		l.end(t, TokenString, true)
+	case l.b[0] == ')' && bytes.Count(t.Raw, []byte{'('}) <= bytes.Count(t.Raw, []byte{')'}):
+		l.end(t, TokenString, true)
+	case l.b[0] == '&':
+		l.state = stateBareAmp
 	default:
-		l.state = stateString
 		t.Raw = append(t.Raw, l.b[0])
 	}
 	return nil
 }
 
-func (l *Lexer) stateStart(t *Token) error {
+// inGroup reports whether the unquoted string t has a '(' not closed yet,
+// like `/(v1|v2)`
+func (l *Lexer) inGroup(t *Token) bool {
+	return bytes.Count(t.Raw, []byte{'('}) > bytes.Count(t.Raw, []byte{')'})
+}
+
+// stateBareBrace decides whether the '{' met in a name or an unquoted
+// string begins a regex repetition, which belongs to the string, or a
+// block. The chars read since the '{' are scanned again in the latter case.
+func (l *Lexer) stateBareBrace(t *Token) error {
+	switch c := l.b[0]; {
+	case c >= '0' && c <= '9':
+		l.brace = append(l.brace, c)
+		return nil
+	case c == ',' && len(l.brace) > 1 && bytes.IndexByte(l.brace, ',') < 0:
+		l.brace = append(l.brace, c)
+		return nil
+	case c == '}' && len(l.brace) > 1:
+		t.Raw = append(append(t.Raw, l.brace...), c)
+		l.state = stateBareString
+		return nil
+	}
+	l.stash = append(append(append([]byte{}, l.brace...), l.b[0]), l.stash...)
+	l.pushedBack = true
+	l.col -= len(l.brace)
+	l.end(t, l.braceType, false)
+	return nil
+}
+
+// stateBareAmp decides whether the '&' met in an unquoted string begins a
+// '&&' or belongs to the string
+func (l *Lexer) stateBareAmp(t *Token) error {
+	if l.b[0] == '&' {
+		l.stash = append([]byte{'&', '&'}, l.stash...)
+		l.pushedBack = true
+		l.col--
+		l.end(t, TokenString, false)
+		return nil
+	}
+	t.Raw = append(t.Raw, '&')
+	l.state = stateBareString
+	l.unread()
+	return nil
+}
+
+func (l *Lexer) stateNot(t *Token) error {
 	switch l.b[0] {
-	case 't':
-		t.Raw = append(t.Raw, l.b[0])
-		l.state = stateTrue
-	case 'f':
-		t.Raw = append(t.Raw, l.b[0])
-		l.state = stateFalse
-	case 'n':
-		t.Raw = append(t.Raw, l.b[0])
-		l.state = stateNull
-	case 'r':
-		t.Raw = append(t.Raw, l.b[0])
-		l.state = stateReturn
-	case '{':
-		t.Type = TokenBlockBegin
-		l.state = stateEnd
-	case '}':
-		t.Type = TokenBlockEnd
-		l.state = stateEnd
-	case '!':
-		l.state = stateNot
 	case '=':
-		l.state = stateAssignment
-	case '>':
-		l.state = stateGT
-	case '<':
-		l.state = stateLT
+		l.end(t, TokenNEQ, false)
 	case '~':
-		t.Type = TokenLike
-		l.state = stateEnd
-	case '|':
-		t.Type = TokenSep
-		l.state = stateEnd
-	case ';':
-		t.Type = TokenStmtEnd
-		l.state = stateEnd
-	case '#':
-		l.state = stateComment
-	case '"':
-		l.state = stateString
-		t.Raw = append(t.Raw, '"')
+		l.end(t, TokenNotLike, false)
 	default:
-		switch {
-		case l.isAlpha() || l.b[0] == '_' || l.b[0] == '-':
-			t.Raw = append(t.Raw, l.b[0])
-			l.state = stateName
-		case l.isNumber():
-			t.Raw = append(t.Raw, l.b[0])
-			l.state = stateNumber
-		case l.isWhitespace():
-			t.Row, t.Col = l.resolvePosition()
-		default:
-			t.Raw = append(t.Raw, l.b[0])
-			l.state = stateString
-		}
+		l.end(t, TokenNot, true)
+	}
+	return nil
+}
+
+func (l *Lexer) stateSep(t *Token) error {
+	if l.b[0] == '|' {
+		l.end(t, TokenOR, false)
+		return nil
+	}
+	l.end(t, TokenSep, true)
+	return nil
+}
+
+func (l *Lexer) stateAmp(t *Token) error {
+	if l.b[0] == '&' {
+		l.end(t, TokenAND, false)
+		return nil
 	}
+	t.Raw = append(t.Raw, '&')
+	l.state = stateBareString
+	l.unread()
+	return nil
+}
+
+func (l *Lexer) stateAssignment(t *Token) error {
+	if l.b[0] == '=' {
+		l.end(t, TokenEQ, false)
+		return nil
+	}
+	l.end(t, TokenAssignment, true)
+	return nil
+}
+
+func (l *Lexer) stateGT(t *Token) error {
+	if l.b[0] == '=' {
+		l.end(t, TokenGTE, false)
+		return nil
+	}
+	l.end(t, TokenGT, true)
+	return nil
+}
+
+func (l *Lexer) stateLT(t *Token) error {
+	switch l.b[0] {
+	case '=':
+		l.end(t, TokenLTE, false)
+		return nil
+	case '<':
+		l.tag = l.tag[:0]
+		l.state = stateHeredocTag
+		return nil
+	}
+	l.end(t, TokenLT, true)
 	return nil
 }
 
 func (l *Lexer) isWhitespace() bool {
-	return l.b[0] == '\t' || l.b[0] == ' ' || l.b[0] == '\n'
+	return l.b[0] == '\t' || l.b[0] == ' ' || l.b[0] == '\n' || l.b[0] == '\r'
 }
 
 func (l *Lexer) isAlpha() bool {
 	return l.b[0] >= 'a' && l.b[0] <= 'z' || l.b[0] >= 'A' && l.b[0] <= 'Z'
 }
 
+func (l *Lexer) isHex() bool {
+	return l.isNumber() || l.b[0] >= 'a' && l.b[0] <= 'f' || l.b[0] >= 'A' && l.b[0] <= 'F'
+}
+
+// isGlued reports whether c is an operator even without whitespace around
+// it, i.e. `+`, `*` or `%`. `-` and `/` belong to names and paths like
+// `request-id` or `text/html`, they need a whitespace or a `)` before them.
+func isGlued(c byte) bool {
+	return c == '+' || c == '*' || c == '%'
+}
+
+// beginsOperand reports whether the current char can begin an operand
+func (l *Lexer) beginsOperand() bool {
+	return l.isAlpha() || l.isNumber() || l.b[0] == '_' || l.b[0] == '(' || l.b[0] == '"' || l.b[0] == '`'
+}
+
+// isUnit reports whether the current char is in a unit of duration, i.e.
+// ns, us, ms, s, m or h
+func (l *Lexer) isUnit() bool {
+	switch l.b[0] {
+	case 'n', 'u', 'm', 's', 'h':
+		return true
+	}
+	return false
+}
+
 func (l *Lexer) isNumber() bool {
 	return l.b[0] >= '0' && l.b[0] <= '9'
 }
 
-func (l *Lexer) isSep() bool {
-	return l.b[0] == ';'
+// isPunct reports whether the current char is a token on its own
+func (l *Lexer) isPunct() bool {
+	switch l.b[0] {
+	case ';', '{', '}', '|':
+		return true
+	}
+	return false
+}
+
+// isOperator reports whether the current char begins an operator
+func (l *Lexer) isOperator() bool {
+	switch l.b[0] {
+	case '=', '!', '<', '>', '~', '&', '(', ')':
+		return true
+	}
+	return false
+}
+
+// isDelimiter reports whether the current char terminates a name or a number
+func (l *Lexer) isDelimiter() bool {
+	return l.isWhitespace() || l.isPunct() || l.isOperator() || l.b[0] == '"' || l.b[0] == '`'
 }
 
 func (l *Lexer) isName() bool {
@@ -5,9 +5,37 @@ import (
 	"testing"
 )
 
+func scanAll(t *testing.T, src string) []Token {
+	t.Helper()
+	bs := bytes.NewBufferString(src)
+	lexer := NewLexer()
+	ret := []Token{}
+	for {
+		token, err := lexer.Scan(bs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.Type == TokenEOF {
+			return ret
+		}
+		ret = append(ret, token)
+	}
+}
+
+func sameTokens(a, b []Token) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type != b[i].Type || !bytes.Equal(a[i].Raw, b[i].Raw) {
+			return false
+		}
+	}
+	return true
+}
+
 func TestLex(t *testing.T) {
 	bs := bytes.NewBuffer([]byte(`
-# the whitespace is very important, please remember use it around any meaningful token
 {
     header.request-id == null {
         header.request-id = uuid;
@@ -43,3 +71,177 @@ func TestLex(t *testing.T) {
 		}
 	}
 }
+
+func TestLex_Tokens(t *testing.T) {
+	cases := []struct {
+		src   string
+		types []int
+		raws  []string
+	}{
+		{"a==b", []int{TokenName, TokenEQ, TokenName}, []string{"a", "", "b"}},
+		{"a!=1.5", []int{TokenName, TokenNEQ, TokenFloat}, []string{"a", "", "1.5"}},
+		{"a>=1", []int{TokenName, TokenGTE, TokenInt}, []string{"a", "", "1"}},
+		{"a<=1", []int{TokenName, TokenLTE, TokenInt}, []string{"a", "", "1"}},
+		{"a<1", []int{TokenName, TokenLT, TokenInt}, []string{"a", "", "1"}},
+		{"a>1", []int{TokenName, TokenGT, TokenInt}, []string{"a", "", "1"}},
+		{"a~.+", []int{TokenName, TokenLike, TokenString}, []string{"a", "", ".+"}},
+		{"a!~/x", []int{TokenName, TokenNotLike, TokenString}, []string{"a", "", "/x"}},
+		{"a=\"b c\";", []int{TokenName, TokenAssignment, TokenString, TokenStmtEnd}, []string{"a", "", "b c", ""}},
+		{"x|y", []int{TokenName, TokenSep, TokenName}, []string{"x", "", "y"}},
+		{"127.0.0.1:6090|/a/*", []int{TokenString, TokenSep, TokenString}, []string{"127.0.0.1:6090", "", "/a/*"}},
+		{"return;", []int{TokenReturn, TokenStmtEnd}, []string{"return", ""}},
+		{"nullable=false", []int{TokenName, TokenAssignment, TokenFalse}, []string{"nullable", "", "false"}},
+		{"x=true}", []int{TokenName, TokenAssignment, TokenTrue, TokenBlockEnd}, []string{"x", "", "true", ""}},
+		{"# comment\nx", []int{TokenComment, TokenName}, []string{" comment", "x"}},
+		{"a", []int{TokenName}, []string{"a"}},
+		{"a&&!b||c", []int{TokenName, TokenAND, TokenNot, TokenName, TokenOR, TokenName}, []string{"a", "", "", "b", "", "c"}},
+		{"(a==/x)", []int{TokenParenBegin, TokenName, TokenEQ, TokenString, TokenParenEnd}, []string{"", "a", "", "/x", ""}},
+		{"a~/(v1)&&b", []int{TokenName, TokenLike, TokenString, TokenAND, TokenName}, []string{"a", "", "/(v1)", "", "b"}},
+		{"/x?a=1&b=2", []int{TokenString}, []string{"/x?a=1&b=2"}},
+		{"for k,v in x", []int{TokenFor, TokenName, TokenComma, TokenName, TokenIn, TokenName}, []string{"for", "k", "", "v", "in", "x"}},
+		{"text/html,text/plain", []int{TokenString}, []string{"text/html,text/plain"}},
+		{"a + -b * /x % 2", []int{TokenName, TokenPlus, TokenName, TokenStar, TokenString, TokenPercent, TokenInt}, []string{"a", "", "-b", "", "/x", "", "2"}},
+		{"a - 1 / b", []int{TokenName, TokenMinus, TokenInt, TokenSlash, TokenName}, []string{"a", "", "1", "", "b"}},
+		{"ttl=30s;", []int{TokenName, TokenAssignment, TokenDuration, TokenStmtEnd}, []string{"ttl", "", "30s", ""}},
+		{"(1h30m)|1.5ms", []int{TokenParenBegin, TokenDuration, TokenParenEnd, TokenSep, TokenDuration}, []string{"", "1h30m", "", "", "1.5ms"}},
+		{"5min 3h2 2s/x", []int{TokenString, TokenString, TokenString}, []string{"5min", "3h2", "2s/x"}},
+		{"path~/api/(v1|v2)/.*{", []int{TokenName, TokenLike, TokenString, TokenBlockBegin}, []string{"path", "", "/api/(v1|v2)/.*", ""}},
+		{`code ~ \d{3}|a{2,3}|x{2,}`, []int{TokenName, TokenLike, TokenString, TokenSep, TokenString, TokenSep, TokenString}, []string{"code", "", `\d{3}`, "", "a{2,3}", "", "x{2,}"}},
+		{"a ~ /(x)|/b{\n}", []int{TokenName, TokenLike, TokenString, TokenSep, TokenString, TokenBlockBegin, TokenBlockEnd}, []string{"a", "", "/(x)", "", "/b", "", ""}},
+		{"x{1a}", []int{TokenName, TokenBlockBegin, TokenString, TokenBlockEnd}, []string{"x", "", "1a", ""}},
+		{"else{12 }", []int{TokenElse, TokenBlockBegin, TokenInt, TokenBlockEnd}, []string{"else", "", "12", ""}},
+		{"x=-1;y -1.5|-5m|-a", []int{TokenName, TokenAssignment, TokenInt, TokenStmtEnd, TokenName, TokenFloat, TokenSep, TokenDuration, TokenSep, TokenName}, []string{"x", "", "-1", "", "y", "-1.5", "", "-5m", "", "-a"}},
+		{"a - -1", []int{TokenName, TokenMinus, TokenInt}, []string{"a", "", "-1"}},
+		{"count+1", []int{TokenName, TokenPlus, TokenInt}, []string{"count", "", "1"}},
+		{"a*2%b", []int{TokenName, TokenStar, TokenInt, TokenPercent, TokenName}, []string{"a", "", "2", "", "b"}},
+		{"count *2", []int{TokenName, TokenStar, TokenInt}, []string{"count", "", "2"}},
+		{"(1 + 2)*3", []int{TokenParenBegin, TokenInt, TokenPlus, TokenInt, TokenParenEnd, TokenStar, TokenInt}, []string{"", "1", "", "2", "", "", "3"}},
+		{"(a)-1 (a)/b", []int{TokenParenBegin, TokenName, TokenParenEnd, TokenMinus, TokenInt, TokenParenBegin, TokenName, TokenParenEnd, TokenSlash, TokenName}, []string{"", "a", "", "", "1", "", "a", "", "", "b"}},
+		{"1.5+ttl+30s", []int{TokenFloat, TokenPlus, TokenName, TokenPlus, TokenDuration}, []string{"1.5", "", "ttl", "", "30s"}},
+		{"count-1 a/2", []int{TokenName, TokenString}, []string{"count-1", "a/2"}},
+		{"*.example.com /a+b", []int{TokenString, TokenString}, []string{"*.example.com", "/a+b"}},
+	}
+	for _, c := range cases {
+		tokens := scanAll(t, c.src)
+		if len(tokens) != len(c.types) {
+			t.Fatalf("%s: expect %d tokens, got %d", c.src, len(c.types), len(tokens))
+		}
+		for i, token := range tokens {
+			if token.Type != c.types[i] || string(token.Raw) != c.raws[i] {
+				t.Fatalf("%s: token %d is [%d %s]", c.src, i, token.Type, token.Raw)
+			}
+		}
+	}
+}
+
+func TestLex_Compact(t *testing.T) {
+	cases := [][2]string{
+		{"a==b;", "a == b ;"},
+		{"host==x|y{", "host == x | y {"},
+		{"path!~/login|/register{response.code=401;}", "path !~ /login | /register {\n\tresponse.code = 401;\n}"},
+		{"backend 127.0.0.1:6090|127.0.0.1:6091;", "backend 127.0.0.1:6090 | 127.0.0.1:6091 ;"},
+		{"response.code>=200{return;}", "response.code >= 200 { return ; }"},
+		{"x=\"a b\";y=1.5;", "x = \"a b\" ;\ny = 1.5 ;"},
+		{"{header.id==null{header.id=uuid;}}", "{ header.id == null { header.id = uuid; } }"},
+		{"!(a==1||b~/x)&&c!=2{", "! ( a == 1 || b ~ /x ) && c != 2 {"},
+	}
+	for _, c := range cases {
+		compact, spaced := scanAll(t, c[0]), scanAll(t, c[1])
+		if !sameTokens(compact, spaced) {
+			t.Fatalf("[%s] and [%s] lexed differently: %v, %v", c[0], c[1], compact, spaced)
+		}
+	}
+}
+
+func TestLex_Position(t *testing.T) {
+	tokens := scanAll(t, "a == b;\n  c = 1;")
+	expect := [][2]int{{1, 1}, {1, 3}, {1, 6}, {1, 7}, {2, 3}, {2, 5}, {2, 7}, {2, 8}}
+	if len(tokens) != len(expect) {
+		t.Fatalf("expect %d tokens, got %d", len(expect), len(tokens))
+	}
+	for i, token := range tokens {
+		if token.Row != expect[i][0] || token.Col != expect[i][1] {
+			t.Fatalf("token %d [%s] at [%d, %d]", i, token.String(), token.Row, token.Col)
+		}
+	}
+
+	tokens = scanAll(t, "x{12\n y}")
+	expect = [][2]int{{1, 1}, {1, 2}, {1, 3}, {2, 2}, {2, 3}}
+	for i, token := range tokens {
+		if token.Row != expect[i][0] || token.Col != expect[i][1] {
+			t.Fatalf("token %d [%s] at [%d, %d]", i, token.String(), token.Row, token.Col)
+		}
+	}
+}
+
+func TestLex_Strings(t *testing.T) {
+	cases := []struct {
+		src    string
+		expect string
+	}{
+		{`"say \"hi\""`, `say "hi"`},
+		{`"a\\b"`, `a\b`},
+		{`"line\nnext\ttab\r"`, "line\nnext\ttab\r"},
+		{`"étÉ"`, "étÉ"},
+		{`""`, ""},
+		{"`^/api/(v1|v2);\\d+$`", `^/api/(v1|v2);\d+$`},
+		{"`a\nb`", "a\nb"},
+		{"<<EOF\n{\"error\": \"unauthorized\"}\nEOF", `{"error": "unauthorized"}`},
+		{"<<EOF\n    {\n      \"a\": 1\n    }\n    EOF", "{\n  \"a\": 1\n}"},
+		{"<< END \nEOFX\nEND", "EOFX"},
+		{"<<EOF\nEOF", ""},
+	}
+	for _, c := range cases {
+		tokens := scanAll(t, c.src)
+		if len(tokens) != 1 || tokens[0].Type != TokenString || string(tokens[0].Raw) != c.expect {
+			t.Fatalf("%s: lexed as %v", c.src, tokens)
+		}
+	}
+	tokens := scanAll(t, "response.body = <<EOF\n  unauthorized\n  EOF;\nx = `a;b`;")
+	if len(tokens) != 8 || string(tokens[2].Raw) != "unauthorized" || tokens[3].Type != TokenStmtEnd || string(tokens[6].Raw) != "a;b" {
+		t.Fatalf("lexed as %v", tokens)
+	}
+	if tokens[4].Row != 4 || tokens[4].Col != 1 {
+		t.Fatalf("x at [%d, %d]", tokens[4].Row, tokens[4].Col)
+	}
+}
+
+func TestLex_StringErrors(t *testing.T) {
+	for _, src := range []string{`"abc`, `"\q"`, `"\u12g4"`, "`abc", "<<EOF\nabc\n", "<<\nabc"} {
+		lexer := NewLexer()
+		bs := bytes.NewBufferString(src)
+		var err error
+		for {
+			var token Token
+			if token, err = lexer.Scan(bs); err != nil || token.Type == TokenEOF {
+				break
+			}
+		}
+		if err == nil {
+			t.Fatalf("%s: expect error", src)
+		}
+	}
+}
+
+func TestLex_Template(t *testing.T) {
+	tokens := scanAll(t, `"Bearer ${token} for ${f "}" x}\${no}$"`)
+	if len(tokens) != 1 || tokens[0].Type != TokenTemplate {
+		t.Fatalf("lexed as %v", tokens)
+	}
+	expect := []TemplatePart{
+		{Raw: []byte("Bearer ")},
+		{Expr: true, Raw: []byte("token"), Row: 1, Col: 11},
+		{Raw: []byte(" for ")},
+		{Expr: true, Raw: []byte(`f "}" x`), Row: 1, Col: 24},
+		{Raw: []byte("${no}$")},
+	}
+	parts := tokens[0].Parts
+	if len(parts) != len(expect) {
+		t.Fatalf("parts: %v", parts)
+	}
+	for i, part := range parts {
+		if part.Expr != expect[i].Expr || !bytes.Equal(part.Raw, expect[i].Raw) || part.Row != expect[i].Row || part.Col != expect[i].Col {
+			t.Fatalf("part %d: %v", i, part)
+		}
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"runtime/debug"
+	"sort"
 	"strings"
 	"time"
 
@@ -24,12 +26,36 @@ func init() {
 	rand.Seed(time.Now().Unix())
 }
 
+// requestVars are the variables bound for each request
+var requestVars = []string{"path", "hash", "scheme", "host", "user-agent", "remote-addr", "method", "header", "response", "query"}
+
 func Init(ctx *ngin.Context) {
+	ctx.Provide(requestVars...)
+	ctx.Provide("read-request-body", "read-response-body")
 	listener := listener{}
 	ctx.BindFunc("listen", listener.listen)
 	ctx.BindFunc("backend", listener.backend)
 	ctx.BindFunc("call", listener.call)
 	ctx.BindFunc("forward", listener.call)
+	ctx.Describe("listen", "listen [network] address [protocol] { ... }\n\nserves the address, the block is executed for each request. cert-file and key-file enable tls")
+	ctx.Describe("backend", "backend url | url ...\n\npicks one of the backends randomly, the request is sent to it by forward")
+	ctx.Describe("call", "call\n\nsends the request to the backend and binds the response")
+	ctx.Describe("forward", "forward\n\nsends the request to the backend and binds the response")
+	ctx.Describe("path", "the path of the request")
+	ctx.Describe("hash", "the fragment of the request url")
+	ctx.Describe("scheme", "the scheme of the request url")
+	ctx.Describe("host", "the host of the request")
+	ctx.Describe("user-agent", "the user agent of the request")
+	ctx.Describe("remote-addr", "the address of the client")
+	ctx.Describe("method", "the method of the request")
+	ctx.Describe("header", "the headers of the request, e.g. header.Authorization")
+	ctx.Describe("query", "the query parameters of the request, e.g. query.page")
+	ctx.Describe("response", "the response sent to the client")
+	ctx.Describe("response.code", "the status code of the response, 200 by default")
+	ctx.Describe("response.body", "the body of the response, the body of the backend by default")
+	ctx.Describe("response.header", "the headers of the response, e.g. response.header.Content-Type")
+	ctx.Describe("read-request-body", "read-request-body\n\nreturns the body of the request")
+	ctx.Describe("read-response-body", "read-response-body\n\nreturns the body of the backend response")
 }
 
 type listener struct{}
@@ -74,7 +100,7 @@ func (listener) listen(ctx *ngin.Context, args ...ngin.Value) (bool, error) {
 	ctx.Logger().Logf(logf.Info, "listen %s", addr)
 	switch protocol {
 	case "http":
-		s := http.Server{Handler: httpHandler{ctx: ctx}}
+		s := http.Server{Handler: Handler(ctx, ctx.Block())}
 		if err := s.Serve(listener); err != nil {
 			ctx.Logger().Logf(logf.Error, "serve http: %s", err.Error())
 		}
@@ -123,15 +149,14 @@ func (listener) RequestFromContext(ctx *ngin.Context) (*http.Request, error) {
 	}
 	for _, key := range keys {
 		if k := key.String(); k != "" {
-			v := ctx.GetValue("header." + k).String()
-			req.Header.Set(k, v)
+			setValues(req.Header, k, ctx.GetValue("header."+k))
 		}
 	}
 	keys = ctx.GetAttr("query").Slice()
 	query := req.URL.Query()
 	for _, key := range keys {
 		if k := key.String(); k != "" {
-			query.Set(k, ctx.GetValue("query."+k).String())
+			setValues(query, k, ctx.GetValue("query."+k))
 		}
 	}
 	req.URL.RawQuery = query.Encode()
@@ -139,15 +164,30 @@ func (listener) RequestFromContext(ctx *ngin.Context) (*http.Request, error) {
 	return req, nil
 }
 
+// values are the headers or the query parameters
+type values interface {
+	Add(key, value string)
+	Del(key string)
+}
+
+// setValues sets the values of a header or a query parameter, a list
+// gives one value for each item
+func setValues(vals values, key string, v ngin.Value) {
+	vals.Del(key)
+	for _, item := range v.Slice() {
+		vals.Add(key, item.String())
+	}
+}
+
 func (h listener) call(ctx *ngin.Context, args ...ngin.Value) (bool, error) {
 	req, err := h.RequestFromContext(ctx)
+	if err != nil {
+		return false, err
+	}
 	if req.URL.Host == "" {
 		ctx.Logger().Logf(logf.Error, "no backend found")
 		return false, nil
 	}
-	if err != nil {
-		return false, err
-	}
 	cli := http.Client{}
 	resp, err := cli.Do(req)
 	if err != nil {
@@ -156,10 +196,10 @@ func (h listener) call(ctx *ngin.Context, args ...ngin.Value) (bool, error) {
 		ctx.Logger().Logf(logf.Error, "call: %s", err.Error())
 		return false, nil
 	}
-	for k := range resp.Header {
+	for _, k := range sortedKeys(resp.Header) {
 		ctx.BindValue("response.header."+k, ngin.String(resp.Header.Get(k)))
 	}
-	ctx.BindValue("response.code", ngin.Int(uint64(resp.StatusCode)))
+	ctx.BindValue("response.code", ngin.Int(int64(resp.StatusCode)))
 	var body []byte
 	ctx.BindValuedFunc("read-response-body", func(_ *ngin.Context, args ...ngin.Value) ngin.Value {
 		if resp.Body == nil {
@@ -182,24 +222,65 @@ func (h listener) call(ctx *ngin.Context, args ...ngin.Value) (bool, error) {
 	return true, nil
 }
 
+// Handler returns the http handler running block for each request, in a
+// frame of its own folked from ctx. ctx is shared by the requests from
+// then on, see ngin.Context.Share.
+func Handler(ctx *ngin.Context, block *ngin.Block) http.Handler {
+	ctx.Share()
+	return httpHandler{ctx: ctx, block: block}
+}
+
 type httpHandler struct {
-	ctx *ngin.Context
+	ctx   *ngin.Context
+	block *ngin.Block
 }
 
+// ServeHTTP runs the block for req. A panic while the request is served
+// is logged with its stack and answered with a 500 if nothing is written
+// yet, the other requests go on.
 func (h httpHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	rw := &responseWriter{ResponseWriter: w}
+	defer func() {
+		if r := recover(); r != nil {
+			h.ctx.Logger().Logf(logf.Error, "serve %s %s: panic: %v\n%s", req.Method, req.URL.Path, r, debug.Stack())
+			if !rw.written {
+				rw.WriteHeader(http.StatusInternalServerError)
+			}
+		}
+	}()
+	h.serve(rw, req)
+}
+
+// responseWriter tells whether the header is written
+type responseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (h httpHandler) serve(w http.ResponseWriter, req *http.Request) {
 	ctx := h.ctx.Folk()
 	ctx.Declare("read-response-body")
 	h.withRequest(ctx, req)
-	var ok bool
 	var err error
-	for _, stmt := range h.ctx.NextStmts() {
-		if ok, err = stmt.Execute(ctx); err != nil || !ok {
-			break
-		}
+	if h.block != nil {
+		_, err = h.block.Run(ctx)
+	}
+	if err != nil {
+		ctx.Logger().Logf(logf.Error, "execute: %s", err.Error())
 	}
-	keys := ctx.GetAttr("response.header.*").Slice()
+	keys := ctx.GetAttr("response.header").Slice()
 	for _, key := range keys {
-		w.Header().Set(key.String(), ctx.GetValue("response.header."+key.String()).String())
+		setValues(w.Header(), key.String(), ctx.GetValue("response.header."+key.String()))
 	}
 	code := 200
 	if c := ctx.GetValue("response.code").Int(); c != 0 {
@@ -217,10 +298,10 @@ func (h httpHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 }
 
 func (h httpHandler) withRequest(ctx *ngin.Context, req *http.Request) *ngin.Context {
-	ctx.Declare("path", "hash", "scheme", "host", "user-agent", "remote-addr", "method", "header", "response", "query")
+	ctx.Declare(requestVars...)
 	ctx.Put("request", req)
 	ctx.BindValuedFunc("read-request-body", h.requestBody)
-	for k := range req.Header {
+	for _, k := range sortedKeys(req.Header) {
 		vals := req.Header.Values(k)
 		if len(vals) == 1 {
 			ctx.BindValue("header."+k, ngin.String(vals[0]))
@@ -239,7 +320,9 @@ func (h httpHandler) withRequest(ctx *ngin.Context, req *http.Request) *ngin.Con
 	ctx.BindValue("user-agent", ngin.String(req.UserAgent()))
 	ctx.BindValue("remote-addr", ngin.String(req.RemoteAddr))
 	ctx.BindValue("method", ngin.String(req.Method))
-	for k, vals := range req.URL.Query() {
+	query := req.URL.Query()
+	for _, k := range sortedKeys(query) {
+		vals := query[k]
 		if len(vals) == 1 {
 			ctx.BindValue("query."+k, ngin.String(vals[0]))
 			continue
@@ -253,6 +336,17 @@ func (h httpHandler) withRequest(ctx *ngin.Context, req *http.Request) *ngin.Con
 	return ctx
 }
 
+// sortedKeys returns the names of the headers or the query parameters
+// sorted, so they're bound in the same order for every request
+func sortedKeys[T ~map[string][]string](values T) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (h httpHandler) requestBody(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
 	req := ctx.Get("request")
 	if req == nil {
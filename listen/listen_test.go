@@ -0,0 +1,136 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package listen_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dev-mockingbird/ngin"
+	"github.com/dev-mockingbird/ngin/listen"
+)
+
+func TestHandler_Concurrent(t *testing.T) {
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: strings.NewReader(`
+var greeting settings;
+greeting = hello;
+settings.lang = en;
+serve {
+    var name;
+    name = query.name;
+    greeting = "${greeting} ${name}";
+    settings.lang = query.lang;
+    header.X-Name ~ ^user- {
+        response.header.x-lang = settings.lang;
+    }
+    response.body = greeting;
+}
+`)}
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := ngin.NewContext()
+	listen.Init(root)
+	var handler http.Handler
+	root.BindFunc("serve", func(ctx *ngin.Context, args ...ngin.Value) (bool, error) {
+		handler = listen.Handler(ctx, ctx.Block())
+		return true, nil
+	})
+	if _, err := ngin.Compile(stmts).Run(root); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/?name=user-%d&lang=l%d", i, i), nil)
+			req.Header.Set("x-name", fmt.Sprintf("user-%d", i))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			body, _ := io.ReadAll(w.Result().Body)
+			if expect := fmt.Sprintf("hello user-%d", i); string(body) != expect {
+				t.Errorf("expect %q, got %q", expect, body)
+			}
+			if lang := w.Result().Header.Get("x-lang"); lang != fmt.Sprintf("l%d", i) {
+				t.Errorf("unexpected lang %q", lang)
+			}
+		}(i)
+		// the host may change the shared frame while requests are served
+		root.BindValue("version", ngin.Int(int64(i)))
+	}
+	wg.Wait()
+	if v := root.GetValue("greeting").String(); v != "hello" {
+		t.Fatalf("a request changed the shared greeting: %s", v)
+	}
+	if v := root.GetValue("settings.lang").String(); v != "en" {
+		t.Fatalf("a request changed the shared settings: %s", v)
+	}
+}
+
+func TestHandler_Recover(t *testing.T) {
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: strings.NewReader(`
+serve {
+    header.Accept ~ json {
+        response.header.x-accept = header.Accept;
+    }
+    query.boom == yes {
+        boom;
+    }
+    backend upstream;
+    call;
+}
+`)}
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Join(r.Header.Values("X-Tag"), ";"))
+	}))
+	defer upstream.Close()
+	root := ngin.NewContext()
+	listen.Init(root)
+	root.BindValue("upstream", ngin.String(upstream.URL))
+	root.BindFunc("boom", func(*ngin.Context, ...ngin.Value) (bool, error) {
+		panic("boom")
+	})
+	var handler http.Handler
+	root.BindFunc("serve", func(ctx *ngin.Context, args ...ngin.Value) (bool, error) {
+		handler = listen.Handler(ctx, ctx.Block())
+		return false, nil
+	})
+	if _, err := ngin.Compile(stmts).Run(root); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?boom=yes", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expect 500 for a panic, got %d", w.Code)
+	}
+
+	// repeated headers are lists, they're forwarded as they are
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Add("Accept", "text/html")
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("X-Tag", "a")
+	req.Header.Add("X-Tag", "b")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Body.String() != "a;b" {
+		t.Fatalf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+	if v := w.Result().Header.Values("X-Accept"); len(v) != 2 || v[1] != "application/json" {
+		t.Fatalf("unexpected accept %v", v)
+	}
+}
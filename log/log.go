@@ -14,6 +14,7 @@ import (
 
 func Init(ctx *ngin.Context) {
 	ctx.BindFunc("log", Log)
+	ctx.Describe("log", "log [level] format args...\n\nwrites a log, level is one of trace, debug, info, warn, error and fatal")
 }
 
 func Log(ctx *ngin.Context, args ...ngin.Value) (bool, error) {
@@ -0,0 +1,241 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package lsp
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/dev-mockingbird/ngin"
+)
+
+// document is an opened file, it's parsed every time it changes
+type document struct {
+	uri    string
+	path   string
+	text   string
+	lines  []string
+	tokens []ngin.Token
+	stmts  []ngin.Stmt
+	err    error
+}
+
+func newDocument(uri, text string) *document {
+	d := &document{uri: uri, path: uri, text: text, lines: strings.Split(text, "\n")}
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		d.path = u.Path
+	}
+	l := ngin.NewLexer()
+	r := strings.NewReader(text)
+	for {
+		t, err := l.Scan(r)
+		var perr ngin.PosError
+		if errors.As(err, &perr) {
+			// the bad token is skipped, the rest of the document being
+			// edited is still highlighted, like the parser resyncs
+			continue
+		}
+		if err != nil || t.Type == ngin.TokenEOF {
+			break
+		}
+		d.tokens = append(d.tokens, t)
+	}
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: strings.NewReader(text), File: d.path}
+	d.stmts, d.err = p.Parse()
+	return d
+}
+
+func (d *document) diagnostics(ctx *ngin.Context) []Diagnostic {
+	ret := []Diagnostic{}
+	if d.err != nil {
+		var errs ngin.ErrorList
+		if !errors.As(d.err, &errs) {
+			errs = ngin.ErrorList{d.err}
+		}
+		for _, err := range errs {
+			var perr ngin.PosError
+			if !errors.As(err, &perr) {
+				ret = append(ret, Diagnostic{Severity: severityError, Source: "ngin", Message: err.Error()})
+				continue
+			}
+			if perr.File != "" && perr.File != d.path {
+				continue
+			}
+			msg := err.Error()
+			if inner := errors.Unwrap(perr); inner != nil {
+				msg = inner.Error()
+			}
+			ret = append(ret, Diagnostic{Range: d.wordRange(perr.Row, perr.Col), Severity: severityError, Source: "ngin", Message: msg})
+		}
+	}
+	for _, diag := range ngin.Check(ctx, d.stmts) {
+		if diag.Pos.File != "" && diag.Pos.File != d.path {
+			continue
+		}
+		severity := severityWarning
+		if diag.Severity == ngin.Error {
+			severity = severityError
+		}
+		ret = append(ret, Diagnostic{Range: d.wordRange(diag.Pos.Row, diag.Pos.Col), Severity: severity, Source: "ngin", Message: diag.Message})
+	}
+	return ret
+}
+
+// position converts a row and a byte column, both from 1, to a position
+func (d *document) position(row, col int) Position {
+	if row < 1 || row > len(d.lines) {
+		return Position{Line: row - 1}
+	}
+	line := d.lines[row-1]
+	if col-1 > len(line) {
+		col = len(line) + 1
+	}
+	return Position{Line: row - 1, Character: utf16Len(line[:col-1])}
+}
+
+// offset converts a position to a row and a byte column, both from 1
+func (d *document) offset(pos Position) (row, col int) {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return pos.Line + 1, 1
+	}
+	line := d.lines[pos.Line]
+	units := 0
+	for i, r := range line {
+		if units >= pos.Character {
+			return pos.Line + 1, i + 1
+		}
+		units += len(utf16.Encode([]rune{r}))
+	}
+	return pos.Line + 1, len(line) + 1
+}
+
+// wordRange is the range of the word beginning at row and col
+func (d *document) wordRange(row, col int) Range {
+	start := d.position(row, col)
+	end := col
+	if row >= 1 && row <= len(d.lines) {
+		line := d.lines[row-1]
+		for end-1 < len(line) && !strings.ContainsRune(" \t\r;{}", rune(line[end-1])) {
+			end++
+		}
+	}
+	if end == col {
+		end++
+	}
+	return Range{Start: start, End: d.position(row, end)}
+}
+
+func (d *document) tokenRange(t ngin.Token) Range {
+	return Range{Start: d.position(t.Row, t.Col), End: d.position(t.Row, t.Col+len(t.Raw))}
+}
+
+// nameAt returns the name token at pos
+func (d *document) nameAt(pos Position) (ngin.Token, bool) {
+	row, col := d.offset(pos)
+	for _, t := range d.tokens {
+		if t.Type == ngin.TokenName && t.Row == row && t.Col <= col && col <= t.Col+len(t.Raw) {
+			return t, true
+		}
+	}
+	return ngin.Token{}, false
+}
+
+// prefix returns the name being typed before pos
+func (d *document) prefix(pos Position) string {
+	row, col := d.offset(pos)
+	if row < 1 || row > len(d.lines) {
+		return ""
+	}
+	line := d.lines[row-1][:col-1]
+	i := len(line)
+	for i > 0 && (isNameByte(line[i-1]) || line[i-1] == '.') {
+		i--
+	}
+	return line[i:]
+}
+
+// definition returns where the variable name is declared by var, or
+// assigned for the first time, or where the function or the parameter
+// name is defined by def, or where the loop variable name is
+func (d *document) definition(name string) (ngin.Token, bool) {
+	root := rootName(name)
+	first, declaring := true, false
+	for i, t := range d.tokens {
+		switch t.Type {
+		case ngin.TokenStmtEnd, ngin.TokenBlockBegin, ngin.TokenBlockEnd:
+			first, declaring = true, false
+			continue
+		case ngin.TokenIn:
+			declaring = false
+			continue
+		case ngin.TokenComment:
+			continue
+		}
+		if first {
+			first = false
+			if t.Type == ngin.TokenName && string(t.Raw) == "var" || t.Type == ngin.TokenDef || t.Type == ngin.TokenFor {
+				declaring = true
+				continue
+			}
+		}
+		if t.Type != ngin.TokenName || rootName(string(t.Raw)) != root {
+			continue
+		}
+		if declaring && string(t.Raw) == root {
+			return t, true
+		}
+		for _, next := range d.tokens[i+1:] {
+			if next.Type == ngin.TokenComment {
+				continue
+			}
+			if next.Type == ngin.TokenAssignment {
+				return t, true
+			}
+			break
+		}
+	}
+	return ngin.Token{}, false
+}
+
+// names returns the names used in the document
+func (d *document) names() []string {
+	ret := []string{}
+	for _, t := range d.tokens {
+		if t.Type == ngin.TokenName {
+			ret = append(ret, string(t.Raw))
+		}
+	}
+	return ret
+}
+
+func (d *document) end() Position {
+	last := d.lines[len(d.lines)-1]
+	return Position{Line: len(d.lines) - 1, Character: utf16Len(last)}
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		n += len(utf16.Encode([]rune{r}))
+	}
+	return n
+}
+
+func isNameByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func rootName(name string) string {
+	if idx := strings.Index(name, "."); idx > -1 {
+		return name[:idx]
+	}
+	return name
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/dev-mockingbird/ngin"
+)
+
+var keywords = []string{"null", "return", "true", "false", "else", "include", "def", "switch", "case", "default", "for", "in", "break", "continue"}
+
+// Server is a language server for ngin scripts speaking json-rpc. It
+// knows the functions bound on its context and the names documented by
+// Context.Describe.
+type Server struct {
+	ctx      *ngin.Context
+	conn     conn
+	docs     map[string]*document
+	shutdown bool
+}
+
+func NewServer(ctx *ngin.Context, r io.Reader, w io.Writer) *Server {
+	return &Server{ctx: ctx, conn: conn{r: bufio.NewReader(r), w: w}, docs: make(map[string]*document)}
+}
+
+// Serve serves until the client asks to exit or the input is closed
+func Serve(ctx *ngin.Context, r io.Reader, w io.Writer) error {
+	return NewServer(ctx, r, w).Serve()
+}
+
+func (s *Server) Serve() error {
+	for {
+		msg, err := s.conn.read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			var rerr *rpcError
+			if errors.As(err, &rerr) {
+				if err := s.conn.reply(json.RawMessage("null"), nil, rerr); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		result, err := s.handle(msg)
+		if msg.ID == nil {
+			continue
+		}
+		if err := s.conn.reply(msg.ID, result, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) handle(msg *message) (any, error) {
+	if s.shutdown && msg.Method != "exit" {
+		return nil, &rpcError{Code: codeInvalidRequest, Message: "server is shut down"}
+	}
+	switch msg.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":           1,
+				"hoverProvider":              true,
+				"definitionProvider":         true,
+				"documentFormattingProvider": true,
+				"completionProvider":         map[string]any{"triggerCharacters": []string{"."}},
+			},
+			"serverInfo": map[string]any{"name": "ngin"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.update(params.TextDocument.URI, params.TextDocument.Text)
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		if len(params.ContentChanges) == 0 {
+			return nil, nil
+		}
+		return nil, s.update(params.TextDocument.URI, params.ContentChanges[len(params.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var params documentParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		return nil, s.conn.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []Diagnostic{}})
+	case "textDocument/hover":
+		return s.position(msg, s.hover)
+	case "textDocument/completion":
+		return s.position(msg, s.completion)
+	case "textDocument/definition":
+		return s.position(msg, s.definition)
+	case "textDocument/formatting":
+		var params documentParams
+		if err := unmarshal(msg.Params, &params); err != nil {
+			return nil, err
+		}
+		d, ok := s.docs[params.TextDocument.URI]
+		if !ok {
+			return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("document %s isn't opened", params.TextDocument.URI)}
+		}
+		return s.format(d), nil
+	}
+	if msg.ID == nil {
+		return nil, nil
+	}
+	return nil, &rpcError{Code: codeMethodNotFound, Message: fmt.Sprintf("method %s not found", msg.Method)}
+}
+
+func (s *Server) update(uri, text string) error {
+	d := newDocument(uri, text)
+	s.docs[uri] = d
+	return s.conn.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: d.diagnostics(s.ctx)})
+}
+
+func (s *Server) position(msg *message, handle func(d *document, pos Position) any) (any, error) {
+	var params positionParams
+	if err := unmarshal(msg.Params, &params); err != nil {
+		return nil, err
+	}
+	d, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil, &rpcError{Code: codeInvalidParams, Message: fmt.Sprintf("document %s isn't opened", params.TextDocument.URI)}
+	}
+	return handle(d, params.Position), nil
+}
+
+func (s *Server) hover(d *document, pos Position) any {
+	t, ok := d.nameAt(pos)
+	if !ok {
+		return nil
+	}
+	name := string(t.Raw)
+	doc := s.ctx.Doc(name)
+	if doc == "" {
+		switch {
+		case s.ctx.GetFunc(name) != nil || s.ctx.GetValuedFunc(name) != nil:
+			doc = "function " + name
+		default:
+			def, ok := d.definition(name)
+			if !ok {
+				return nil
+			}
+			doc = fmt.Sprintf("variable %s, defined at %d:%d", rootName(name), def.Row, def.Col)
+		}
+	}
+	return Hover{Contents: MarkupContent{Kind: "markdown", Value: "```ngin\n" + name + "\n```\n" + doc}, Range: d.tokenRange(t)}
+}
+
+func (s *Server) definition(d *document, pos Position) any {
+	t, ok := d.nameAt(pos)
+	if !ok {
+		return nil
+	}
+	def, ok := d.definition(string(t.Raw))
+	if !ok {
+		return nil
+	}
+	def.Raw = []byte(rootName(string(def.Raw)))
+	return Location{URI: d.uri, Range: d.tokenRange(def)}
+}
+
+// completion completes the functions and the variables, or the attributes
+// after a '.', from the names used in the document and the names
+// described on the context
+func (s *Server) completion(d *document, pos Position) any {
+	prefix := d.prefix(pos)
+	known := append(d.names(), s.ctx.Described()...)
+	items := []CompletionItem{}
+	seen := map[string]struct{}{}
+	add := func(label string, kind int, doc string) {
+		if _, ok := seen[label]; ok {
+			return
+		}
+		seen[label] = struct{}{}
+		items = append(items, CompletionItem{Label: label, Kind: kind, Documentation: doc})
+	}
+	if idx := strings.LastIndex(prefix, "."); idx > -1 {
+		base := prefix[:idx+1]
+		for _, name := range known {
+			if !strings.HasPrefix(name, base) || name == prefix {
+				continue
+			}
+			attr := strings.SplitN(name[len(base):], ".", 2)[0]
+			if attr != "" {
+				add(attr, kindField, s.ctx.Doc(base+attr))
+			}
+		}
+	} else {
+		for _, name := range s.ctx.FuncNames() {
+			add(name, kindFunction, s.ctx.Doc(name))
+		}
+		for _, name := range known {
+			if root := rootName(name); root != prefix {
+				add(root, kindVariable, s.ctx.Doc(root))
+			}
+		}
+		for _, keyword := range keywords {
+			add(keyword, kindKeyword, "")
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Label < items[j].Label })
+	return items
+}
+
+func (s *Server) format(d *document) []TextEdit {
+	res, err := ngin.FormatSource([]byte(d.text), d.path)
+	if err != nil || string(res) == d.text {
+		return []TextEdit{}
+	}
+	return []TextEdit{{Range: Range{End: d.end()}, NewText: string(res)}}
+}
+
+func unmarshal(params json.RawMessage, v any) error {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
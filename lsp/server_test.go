@@ -0,0 +1,194 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package lsp_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dev-mockingbird/ngin"
+	"github.com/dev-mockingbird/ngin/lsp"
+)
+
+type response struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code int `json:"code"`
+	} `json:"error"`
+}
+
+func frame(msgs ...string) io.Reader {
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		fmt.Fprintf(&buf, "Content-Length: %d\r\n\r\n%s", len(msg), msg)
+	}
+	return &buf
+}
+
+func readAll(t *testing.T, r io.Reader) []response {
+	br := bufio.NewReader(r)
+	ret := []response{}
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			return ret
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "Content-Length:")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.ReadString('\n')
+		body := make([]byte, length)
+		if _, err := io.ReadFull(br, body); err != nil {
+			t.Fatal(err)
+		}
+		var resp response
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, resp)
+	}
+}
+
+func TestServer(t *testing.T) {
+	ctx := ngin.NewContext()
+	ctx.BindFunc("forward", func(*ngin.Context, ...ngin.Value) (bool, error) { return true, nil })
+	ctx.Describe("forward", "forward\n\nsends the request")
+	ctx.Describe("response.header", "the headers of the response")
+	ctx.Provide("header", "response")
+	text := `var user;
+header.x-user == null {
+response.header.x-user=user;
+  response.
+    forward;
+}
+`
+	open, _ := json.Marshal(map[string]any{"textDocument": map[string]any{"uri": "file:///a.ngin", "text": text, "version": 1}})
+	at := func(id int, method string, line, char int) string {
+		return fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"method":"%s","params":{"textDocument":{"uri":"file:///a.ngin"},"position":{"line":%d,"character":%d}}}`, id, method, line, char)
+	}
+	in := frame(
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`,
+		`{"jsonrpc":"2.0","method":"initialized","params":{}}`,
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":`+string(open)+`}`,
+		at(2, "textDocument/hover", 4, 6),
+		at(3, "textDocument/completion", 3, 11),
+		at(4, "textDocument/definition", 2, 24),
+		`{"jsonrpc":"2.0","id":5,"method":"textDocument/formatting","params":{"textDocument":{"uri":"file:///a.ngin"}}}`,
+		`{"jsonrpc":"2.0","id":6,"method":"unknown"}`,
+		`{"jsonrpc":"2.0","id":7,"method":"shutdown"}`,
+		`{"jsonrpc":"2.0","method":"exit"}`,
+	)
+	var out bytes.Buffer
+	if err := lsp.Serve(ctx, in, &out); err != nil {
+		t.Fatal(err)
+	}
+	resps := readAll(t, &out)
+	if len(resps) != 8 {
+		t.Fatalf("expect 8 messages, got %d", len(resps))
+	}
+	var diagnostics struct {
+		Diagnostics []lsp.Diagnostic `json:"diagnostics"`
+	}
+	json.Unmarshal(resps[1].Params, &diagnostics)
+	if resps[1].Method != "textDocument/publishDiagnostics" || len(diagnostics.Diagnostics) != 2 {
+		t.Fatalf("unexpected diagnostics: %s", resps[1].Params)
+	}
+	if d := diagnostics.Diagnostics[0]; d.Range.Start != (lsp.Position{Line: 2, Character: 0}) || d.Severity != 2 {
+		t.Fatalf("unexpected diagnostic: %#v", d)
+	}
+	// the attribute being typed is taken as a function call
+	if d := diagnostics.Diagnostics[1]; d.Range != (lsp.Range{Start: lsp.Position{Line: 3, Character: 2}, End: lsp.Position{Line: 3, Character: 11}}) || d.Severity != 1 {
+		t.Fatalf("unexpected diagnostic: %#v", d)
+	}
+	var hover lsp.Hover
+	json.Unmarshal(resps[2].Result, &hover)
+	if !strings.Contains(hover.Contents.Value, "sends the request") {
+		t.Fatalf("unexpected hover: %s", resps[2].Result)
+	}
+	var items []lsp.CompletionItem
+	json.Unmarshal(resps[3].Result, &items)
+	if len(items) != 1 || items[0].Label != "header" || items[0].Documentation != "the headers of the response" {
+		t.Fatalf("unexpected completion: %s", resps[3].Result)
+	}
+	var loc lsp.Location
+	json.Unmarshal(resps[4].Result, &loc)
+	if loc.Range.Start != (lsp.Position{Line: 0, Character: 4}) || loc.Range.End != (lsp.Position{Line: 0, Character: 8}) {
+		t.Fatalf("unexpected definition: %s", resps[4].Result)
+	}
+	var edits []lsp.TextEdit
+	json.Unmarshal(resps[5].Result, &edits)
+	if len(edits) != 1 || edits[0].Range.End != (lsp.Position{Line: 6, Character: 0}) {
+		t.Fatalf("unexpected edits: %s", resps[5].Result)
+	}
+	if resps[6].Error == nil || resps[6].Error.Code != -32601 {
+		t.Fatalf("expect method not found")
+	}
+	if *resps[7].ID != 7 || string(resps[7].Result) != "null" {
+		t.Fatalf("unexpected shutdown response")
+	}
+}
+
+func TestServer_Format(t *testing.T) {
+	open, _ := json.Marshal(map[string]any{"textDocument": map[string]any{"uri": "file:///a.ngin", "text": "a==b{c=1;}", "version": 1}})
+	change := `{"jsonrpc":"2.0","method":"textDocument/didChange","params":{"textDocument":{"uri":"file:///a.ngin","version":2},"contentChanges":[{"text":"a == {\n"}]}}`
+	in := frame(
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":`+string(open)+`}`,
+		`{"jsonrpc":"2.0","id":1,"method":"textDocument/formatting","params":{"textDocument":{"uri":"file:///a.ngin"}}}`,
+		change,
+		`{"jsonrpc":"2.0","id":2,"method":"textDocument/formatting","params":{"textDocument":{"uri":"file:///a.ngin"}}}`,
+	)
+	var out bytes.Buffer
+	if err := lsp.Serve(ngin.NewContext(), in, &out); err != nil {
+		t.Fatal(err)
+	}
+	resps := readAll(t, &out)
+	var edits []lsp.TextEdit
+	json.Unmarshal(resps[1].Result, &edits)
+	if len(edits) != 1 || edits[0].NewText != "a == b {\n    c = 1;\n}\n" || edits[0].Range.End != (lsp.Position{Line: 0, Character: 10}) {
+		t.Fatalf("unexpected edits: %s", resps[1].Result)
+	}
+	var diagnostics struct {
+		Diagnostics []lsp.Diagnostic `json:"diagnostics"`
+	}
+	json.Unmarshal(resps[2].Params, &diagnostics)
+	if len(diagnostics.Diagnostics) == 0 || diagnostics.Diagnostics[0].Range.Start != (lsp.Position{Line: 0, Character: 5}) {
+		t.Fatalf("unexpected diagnostics: %s", resps[2].Params)
+	}
+	if string(resps[3].Result) != "[]" {
+		t.Fatalf("a document with errors shouldn't be formatted: %s", resps[3].Result)
+	}
+}
+
+func TestServer_LexError(t *testing.T) {
+	ctx := ngin.NewContext()
+	ctx.BindFunc("forward", func(*ngin.Context, ...ngin.Value) (bool, error) { return true, nil })
+	ctx.Describe("forward", "forward\n\nsends the request")
+	open, _ := json.Marshal(map[string]any{"textDocument": map[string]any{"uri": "file:///a.ngin", "text": "x = \"\\q\";\nforward;\n", "version": 1}})
+	in := frame(
+		`{"jsonrpc":"2.0","method":"textDocument/didOpen","params":`+string(open)+`}`,
+		`{"jsonrpc":"2.0","id":1,"method":"textDocument/hover","params":{"textDocument":{"uri":"file:///a.ngin"},"position":{"line":1,"character":2}}}`,
+	)
+	var out bytes.Buffer
+	if err := lsp.Serve(ctx, in, &out); err != nil {
+		t.Fatal(err)
+	}
+	resps := readAll(t, &out)
+	var hover lsp.Hover
+	json.Unmarshal(resps[1].Result, &hover)
+	if !strings.Contains(hover.Contents.Value, "sends the request") {
+		t.Fatalf("the tokens after a bad one should be kept, got hover %s", resps[1].Result)
+	}
+}
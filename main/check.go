@@ -0,0 +1,44 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/dev-mockingbird/ngin"
+)
+
+// checkCommand reports the problems of a config without running it, it
+// fails if there's any error
+//
+//	ngin check [-config file]
+func checkCommand(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	var confPath string
+	flags.StringVar(&confPath, "config", "/etc/ngin/config.ngin", "pathfile of the config")
+	flags.Parse(args)
+	status := 0
+	stmts, err := ngin.ParseFile(confPath)
+	if err != nil {
+		var errs ngin.ErrorList
+		if !errors.As(err, &errs) {
+			errs = ngin.ErrorList{err}
+		}
+		for _, err := range errs {
+			fmt.Printf("%s\n", err.Error())
+		}
+		status = 1
+	}
+	for _, d := range ngin.Check(newContext(), stmts) {
+		fmt.Printf("%s\n", d.String())
+		if d.Severity == ngin.Error {
+			status = 1
+		}
+	}
+	return status
+}
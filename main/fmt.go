@@ -0,0 +1,86 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/dev-mockingbird/ngin"
+)
+
+// formatCommand formats config files, it reads stdin when there's no file
+//
+//	ngin fmt [-w] [-d] [files...]
+func formatCommand(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write result to the source file instead of stdout")
+	diff := flags.Bool("d", false, "display diffs instead of rewriting files")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: ngin fmt [-w] [-d] [files...]\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintf(os.Stderr, "fmt: cannot use -w with standard input\n")
+			return 2
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fmt: %s\n", err.Error())
+			return 1
+		}
+		if err := formatFile("<standard input>", src, false, *diff); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			return 1
+		}
+		return 0
+	}
+	status := 0
+	for _, file := range flags.Args() {
+		src, err := os.ReadFile(file)
+		if err == nil {
+			err = formatFile(file, src, *write, *diff)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+			status = 1
+		}
+	}
+	return status
+}
+
+func formatFile(file string, src []byte, write, diff bool) error {
+	res, err := ngin.FormatSource(src, file)
+	if err != nil {
+		return err
+	}
+	if diff {
+		if !bytes.Equal(src, res) {
+			fmt.Printf("diff %s %s.formatted\n", file, file)
+			os.Stdout.Write(unifiedDiff(file, file+".formatted", src, res))
+		}
+		if !write {
+			return nil
+		}
+	}
+	if write {
+		if bytes.Equal(src, res) {
+			return nil
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(file, res, info.Mode().Perm())
+	}
+	_, err = os.Stdout.Write(res)
+	return err
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dev-mockingbird/ngin/lsp"
+)
+
+// lspCommand runs the language server on stdin and stdout
+//
+//	ngin lsp
+func lspCommand(args []string) int {
+	if err := lsp.Serve(newContext(), os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "lsp: %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
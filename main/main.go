@@ -9,44 +9,53 @@ import (
 	"flag"
 	"fmt"
 	"os"
-	"sync"
 
 	"github.com/dev-mockingbird/ngin"
+	"github.com/dev-mockingbird/ngin/crypto"
 	"github.com/dev-mockingbird/ngin/encoding"
 	"github.com/dev-mockingbird/ngin/listen"
 	"github.com/dev-mockingbird/ngin/log"
 	"github.com/dev-mockingbird/ngin/redis"
+	"github.com/dev-mockingbird/ngin/strings"
+	"github.com/dev-mockingbird/ngin/time"
 )
 
+// commands are the subcommands, ngin without a subcommand runs the config
+var commands = map[string]func(args []string) int{
+	"fmt":   formatCommand,
+	"check": checkCommand,
+	"lsp":   lspCommand,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			os.Exit(command(os.Args[2:]))
+		}
+	}
 	var confPath string
 	flag.StringVar(&confPath, "config", "/etc/ngin/config.ngin", "pathfile of the config")
 	flag.Parse()
-	fs, err := os.OpenFile(confPath, os.O_RDONLY, 0644)
+	ctx := newContext()
+	stmts, err := ngin.ParseFile(confPath)
 	if err != nil {
-		fmt.Printf("open config file: %s\n", err.Error())
+		fmt.Printf("parse: %s\n", err.Error())
 		os.Exit(1)
 	}
+	if _, err := ngin.Compile(stmts).Run(ctx); err != nil {
+		fmt.Printf("%s\n", err.Error())
+	}
+}
+
+// newContext returns a context with all the functions bound
+func newContext() *ngin.Context {
 	ctx := ngin.NewContext()
 	listen.Init(ctx)
 	log.Init(ctx)
 	encoding.Init(ctx)
+	crypto.Init(ctx)
 	redis.Init(ctx)
-	parser := ngin.Parser{Lexer: ngin.NewLexer(), Reader: fs}
-	stmts, err := parser.Parse()
-	if err != nil {
-		fmt.Printf("parse: %s\n", err.Error())
-		os.Exit(1)
-	}
-	var wg sync.WaitGroup
-	for _, stmt := range stmts {
-		wg.Add(1)
-		// go func(stmt ngin.Stmt, ctx *ngin.Context) {
-		if _, err := stmt.Execute(ctx); err != nil {
-			fmt.Printf("%s\n", err.Error())
-		}
-		wg.Done()
-		// }(stmt, ctx)
-	}
-	wg.Wait()
+	strings.Init(ctx)
+	time.Init(ctx)
+	return ctx
 }
@@ -4,3 +4,130 @@
 // https://opensource.org/licenses/MIT
 
 package ngin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// Map is a map keeping its keys in the order they're first set. FromValue
+// converts a complex value to it and ToValue converts it back, it's
+// encoded as a json object with the keys in order, and decoded from one
+// keeping the order of its keys, the nested objects are decoded as Maps
+// too and the numbers as json.Number.
+type Map struct {
+	keys   []string
+	values map[string]any
+}
+
+func NewMap() *Map {
+	return &Map{values: make(map[string]any)}
+}
+
+// Set sets the value of key, a new key goes after the others
+func (m *Map) Set(key string, value any) {
+	if m.values == nil {
+		m.values = make(map[string]any)
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+func (m *Map) Get(key string) (any, bool) {
+	v, ok := m.values[key]
+	return v, ok
+}
+
+// Keys returns the keys in order
+func (m *Map) Keys() []string {
+	return m.keys
+}
+
+func (m *Map) Len() int {
+	return len(m.keys)
+}
+
+func (m *Map) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, k := range m.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		val, err := json.Marshal(m.values[k])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+func (m *Map) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != json.Delim('{') {
+		return errors.New("json: not an object")
+	}
+	*m = Map{}
+	return m.decode(dec)
+}
+
+// decode decodes the members of an object, the '{' is read already
+func (m *Map) decode(dec *json.Decoder) error {
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		v, err := DecodeJSON(dec)
+		if err != nil {
+			return err
+		}
+		m.Set(t.(string), v)
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// DecodeJSON decodes the next json value from dec, the objects are
+// decoded as Maps keeping the order of their keys and the numbers as
+// json.Number if dec uses numbers, see json.Decoder.UseNumber. ToValue
+// converts the result to a Value.
+func DecodeJSON(dec *json.Decoder) (any, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case json.Delim('{'):
+		m := NewMap()
+		return m, m.decode(dec)
+	case json.Delim('['):
+		items := []any{}
+		for dec.More() {
+			item, err := DecodeJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		_, err := dec.Token()
+		return items, err
+	}
+	return t, nil
+}
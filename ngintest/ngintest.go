@@ -0,0 +1,31 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+// Package ngintest helps to test the packages binding functions to ngin
+package ngintest
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dev-mockingbird/ngin"
+)
+
+// Run parses and runs src in a new context, which init binds the functions
+// to and names are declared in. It stops the test if src can't be parsed,
+// and returns the context with the error of the run.
+func Run(t testing.TB, src string, init func(*ngin.Context), names ...string) (*ngin.Context, error) {
+	t.Helper()
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: strings.NewReader(src)}
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := ngin.NewContext()
+	init(ctx)
+	ctx.Declare(names...)
+	_, err = ngin.Compile(stmts).Run(ctx)
+	return ctx, err
+}
@@ -15,7 +15,7 @@ func (Null) Bytes() []byte {
 	return nil
 }
 
-func (Null) Int() uint64 {
+func (Null) Int() int64 {
 	return 0
 }
 
@@ -36,10 +36,7 @@ func (n Null) Slice() []Value {
 }
 
 func (n Null) Compare(v Value) int {
-	if _, ok := v.(Null); ok {
-		return 0
-	}
-	return -1
+	return compare(n, v)
 }
 
 func (n Null) Value() Value {
@@ -6,17 +6,32 @@
 package ngin
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func ErrUnexpectedToken(t *Token) error {
-	return fmt.Errorf("unexpected token [%s] at [%d, %d]", t.String(), t.Row, t.Col)
+	return PosError{Row: t.Row, Col: t.Col, err: fmt.Errorf("unexpected token [%s]", t.String())}
 }
 
 var operatorMap map[int]Operator
 
+var arithMap = map[int]ArithOperator{
+	TokenPlus:    Add,
+	TokenMinus:   Sub,
+	TokenStar:    Mul,
+	TokenSlash:   Div,
+	TokenPercent: Mod,
+}
+
 func init() {
 	operatorMap = map[int]Operator{
 		TokenEQ:      EQ,
@@ -24,56 +39,212 @@ func init() {
 		TokenGTE:     GTE,
 		TokenGT:      GT,
 		TokenLTE:     LTE,
+		TokenLT:      LT,
 		TokenLike:    Like,
 		TokenNotLike: NotLike,
 	}
 }
 
-// Stmt -> Stmt? { Stmt;* } | SimpleStmt;
-// SimpleStmt -> BoolStmt | AssignmentStmt | CallStmt
-// BoolStmt -> ComparableValue Operator ComparableValue
-// ComparableValue -> Array | Name | Value
+// Stmt -> Condition? { Stmt;* } | Condition { Stmt;* } Else | IncludeStmt | DefStmt | SimpleStmt;
+// IncludeStmt -> 'include' Path ;
+// DefStmt -> 'def' Name Name* { Stmt;* }
+// ForStmt -> 'for' Name ( ',' Name )? 'in' Value { Stmt;* }
+// BreakStmt -> 'break' ;
+// ContinueStmt -> 'continue' ;
+// SwitchStmt -> 'switch' Value { Case* }
+// Case -> 'case' '~'? Array { Stmt;* } | 'default' { Stmt;* }
+// Else -> 'else' { Stmt;* } | 'else' Condition { Stmt;* } Else?
+// Condition -> AndCondition ( '||' AndCondition )*
+// AndCondition -> UnaryCondition ( '&&' UnaryCondition )*
+// UnaryCondition -> '!' UnaryCondition | '(' Condition ')' | BoolStmt | CallStmt
+// SimpleStmt -> BoolStmt | AssignmentStmt | CallStmt | ReturnStmt
+// ReturnStmt -> 'return' Expr?
+// BoolStmt -> Expr Operator Expr
+// Expr -> Term ( ( '+' | '-' ) Term )*
+// Term -> ComparableValue ( ( '*' | '/' | '%' ) ComparableValue )*
+// ComparableValue -> Array | Name | Value | '(' Expr ')'
 // Array -> Name | Value '|' Name | Value
 // Operator -> GT | LT | GTE | LTE | LIKE | NotLike | EQ | NEQ
-// AssignmentStmt -> Name = Value
+// AssignmentStmt -> Name = Expr
 // CallStmt -> Name Name|Value*
+//
+// File is the path of the script being parsed, it's reported in errors
+// and included files are resolved relative to its directory. The files
+// aren't parsed if SkipInclude is set, the include statements are kept
+// without the statements of the files.
 type Parser struct {
-	Lexer  *Lexer
-	Reader io.Reader
-	token  Token
+	Lexer       *Lexer
+	Reader      io.Reader
+	File        string
+	SkipInclude bool
+	token       Token
+	comments    []Comment
+	// last is the type of the last used token
+	last int
+	errs ErrorList
+	// parents are the files including this one, used to detect cycles
+	parents []string
+	// loops is how many for loops the statement being parsed is in
+	loops int
+	// broken is the error of the reader, the tokens can't be scanned
+	// any more once it's set
+	broken error
+}
+
+// Comment is a '# xxx' line, Text doesn't contain the leading '#'
+type Comment struct {
+	Pos  Pos
+	Text string
+}
+
+// ErrorList collects the errors found while parsing a script
+type ErrorList []error
+
+func (l ErrorList) Error() string {
+	msgs := make([]string, len(l))
+	for i, err := range l {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (l ErrorList) Unwrap() []error {
+	return l
 }
 
+// ParseFile parses the script at path, including the files it refers to
+func ParseFile(path string) ([]Stmt, error) {
+	fs, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fs.Close()
+	p := Parser{Lexer: NewLexer(), Reader: bufio.NewReader(fs), File: path}
+	return p.Parse()
+}
+
+// Parse parses the whole script. It doesn't stop at the first syntax
+// error, instead it skips to the end of the broken statement and goes on,
+// so the returned ErrorList reports every error along with the
+// statements which could be parsed. It stops at once if the reader fails.
 func (p *Parser) Parse() (ret []Stmt, err error) {
+	p.errs = nil
 	for {
 		if err = p.nextToken(); err != nil {
-			return
+			if !p.resync(err) {
+				return ret, p.errs
+			}
+			continue
 		}
-		if p.token.Type == TokenEOF {
+		switch p.token.Type {
+		case TokenEOF:
 			p.useToken()
-			return
+			if len(p.errs) > 0 {
+				return ret, p.errs
+			}
+			return ret, nil
+		case TokenBlockEnd:
+			p.addError(ErrUnexpectedToken(&p.token))
+			p.useToken()
+			continue
 		}
 		var stmt Stmt
 		if stmt, err = p.Stmt(); err != nil {
-			return
+			p.fail(err)
+			continue
 		}
 		ret = append(ret, stmt)
 	}
 }
 
+func (p *Parser) addError(err error) {
+	if l, ok := err.(ErrorList); ok {
+		p.errs = append(p.errs, l...)
+		return
+	}
+	err = p.positioned(err)
+	// the same error may be met again while recovering from it
+	if len(p.errs) > 0 && p.errs[len(p.errs)-1].Error() == err.Error() {
+		return
+	}
+	p.errs = append(p.errs, err)
+}
+
+// fail records err then skips the tokens up to the end of the broken
+// statement, i.e. the next ';' or the '}' closing the enclosing block. A
+// block met on the way is parsed to report its errors too.
+func (p *Parser) fail(err error) {
+	p.addError(err)
+	if p.token.Type == TokenEmpty && (p.last == TokenStmtEnd || p.last == TokenBlockEnd) {
+		return
+	}
+	for {
+		if err := p.nextToken(); err != nil {
+			if !p.resync(err) {
+				return
+			}
+			continue
+		}
+		switch p.token.Type {
+		case TokenEOF, TokenBlockEnd:
+			return
+		case TokenStmtEnd:
+			p.useToken()
+			return
+		case TokenBlockBegin:
+			if _, err := p.Stmt(); err != nil {
+				p.addError(err)
+			}
+			return
+		}
+		p.useToken()
+	}
+}
+
+// resync records err met while scanning a token, it reports whether the
+// parsing can go on: a syntax error is skipped since the lexer consumed
+// the broken input, but a failed reader would fail again and again
+func (p *Parser) resync(err error) bool {
+	p.addError(err)
+	return p.broken == nil
+}
+
+// positioned makes sure err tells where it happened
+func (p *Parser) positioned(err error) error {
+	var pe PosError
+	if !errors.As(err, &pe) {
+		return PosError{File: p.File, Row: p.token.Row, Col: p.token.Col, err: err}
+	}
+	if pe.File == "" {
+		pe.File = p.File
+		return pe
+	}
+	return err
+}
+
 func (p *Parser) Stmt() (Stmt, error) {
 	switch p.token.Type {
 	case TokenBlockBegin:
-		stmt := MatchThenStmt{Match: EmptyStmt{}, Stmts: []Stmt{}}
+		stmt := MatchThenStmt{Pos: p.pos(&p.token), Match: EmptyStmt{}, Stmts: []Stmt{}}
 		p.useToken()
 		for {
 			if err := p.nextToken(); err != nil {
-				return nil, err
+				if !p.resync(err) {
+					return nil, err
+				}
+				continue
 			}
+			if p.token.Type == TokenEOF {
+				return nil, ErrUnexpectedToken(&p.token)
+			}
+			end := p.pos(&p.token)
 			s, err := p.Stmt()
 			if err != nil {
-				return nil, err
+				p.fail(err)
+				continue
 			}
 			if _, ok := s.(EmptyStmt); ok {
+				stmt.End = end
 				return stmt, nil
 			}
 			stmt.Stmts = append(stmt.Stmts, s)
@@ -81,8 +252,19 @@ func (p *Parser) Stmt() (Stmt, error) {
 	case TokenBlockEnd:
 		p.useToken()
 		return EmptyStmt{}, nil
+	case TokenInclude:
+		return p.includeStmt()
+	case TokenDef:
+		return p.defStmt()
+	case TokenSwitch:
+		return p.switchStmt()
+	case TokenFor:
+		return p.forStmt()
+	case TokenBreak, TokenContinue:
+		return p.loopControl()
 	default:
-		stmt, err := p.smallStmt()
+		pos := p.pos(&p.token)
+		stmt, err := p.condition()
 		if err != nil {
 			return stmt, err
 		}
@@ -104,18 +286,463 @@ func (p *Parser) Stmt() (Stmt, error) {
 			// this should never reach
 			return nil, errors.New("this should never be reached")
 		}
+		mts.Pos = pos
 		mts.Match = stmt
+		if mts.Else, err = p.elseStmt(); err != nil {
+			return nil, err
+		}
 		return mts, nil
 	}
 }
 
+func (p *Parser) includeStmt() (Stmt, error) {
+	token := p.token
+	p.useToken()
+	if err := p.nextToken(); err != nil {
+		return nil, err
+	}
+	if p.token.Type != TokenString && p.token.Type != TokenName {
+		return nil, ErrUnexpectedToken(&p.token)
+	}
+	path := string(p.token.Raw)
+	p.useToken()
+	if err := p.nextToken(); err != nil {
+		return nil, err
+	}
+	if p.token.Type != TokenStmtEnd {
+		return nil, ErrUnexpectedToken(&p.token)
+	}
+	p.useToken()
+	if p.SkipInclude {
+		return IncludeStmt{Pos: p.pos(&token), Path: path}, nil
+	}
+	stmts, err := p.include(path)
+	if err != nil {
+		var pe PosError
+		if errors.As(err, &pe) && pe.File != "" {
+			return nil, err
+		}
+		return nil, PosError{File: p.File, Row: token.Row, Col: token.Col, err: err}
+	}
+	return IncludeStmt{Pos: p.pos(&token), Path: path, Stmts: stmts}, nil
+}
+
+func (p *Parser) defStmt() (Stmt, error) {
+	stmt := DefStmt{Pos: p.pos(&p.token)}
+	p.useToken()
+	if err := p.nextToken(); err != nil {
+		return nil, err
+	}
+	if p.token.Type != TokenName {
+		return nil, ErrUnexpectedToken(&p.token)
+	}
+	if stmt.Name = string(p.token.Raw); strings.Contains(stmt.Name, ".") {
+		return nil, p.pos(&p.token).wrap(fmt.Errorf("invalid function name %s", stmt.Name))
+	}
+	p.useToken()
+	for {
+		if err := p.nextToken(); err != nil {
+			return nil, err
+		}
+		if p.token.Type != TokenName {
+			break
+		}
+		param := string(p.token.Raw)
+		if strings.Contains(param, ".") {
+			return nil, p.pos(&p.token).wrap(fmt.Errorf("invalid parameter %s", param))
+		}
+		for _, prev := range stmt.Params {
+			if prev == param {
+				return nil, p.pos(&p.token).wrap(fmt.Errorf("duplicate parameter %s", param))
+			}
+		}
+		stmt.Params = append(stmt.Params, param)
+		p.useToken()
+	}
+	if p.token.Type != TokenBlockBegin {
+		return nil, ErrUnexpectedToken(&p.token)
+	}
+	// break and continue can't leave the function
+	loops := p.loops
+	p.loops = 0
+	body, err := p.Stmt()
+	p.loops = loops
+	if err != nil {
+		return nil, err
+	}
+	mt := body.(MatchThenStmt)
+	stmt.Stmts, stmt.End = mt.Stmts, mt.End
+	return stmt, nil
+}
+
+func (p *Parser) forStmt() (Stmt, error) {
+	stmt := ForStmt{Pos: p.pos(&p.token)}
+	p.useToken()
+	names := []string{}
+	for {
+		if err := p.nextToken(); err != nil {
+			return nil, err
+		}
+		if p.token.Type != TokenName {
+			return nil, ErrUnexpectedToken(&p.token)
+		}
+		name := string(p.token.Raw)
+		if strings.Contains(name, ".") {
+			return nil, p.pos(&p.token).wrap(fmt.Errorf("invalid loop variable %s", name))
+		}
+		if len(names) > 0 && names[0] == name {
+			return nil, p.pos(&p.token).wrap(fmt.Errorf("duplicate loop variable %s", name))
+		}
+		names = append(names, name)
+		p.useToken()
+		if err := p.nextToken(); err != nil {
+			return nil, err
+		}
+		if p.token.Type != TokenComma || len(names) == 2 {
+			break
+		}
+		p.useToken()
+	}
+	if p.token.Type != TokenIn {
+		return nil, ErrUnexpectedToken(&p.token)
+	}
+	p.useToken()
+	stmt.Item = names[len(names)-1]
+	if len(names) == 2 {
+		stmt.Key = names[0]
+	}
+	if err := p.nextToken(); err != nil {
+		return nil, err
+	}
+	in, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if in == nil {
+		return nil, ErrUnexpectedToken(&p.token)
+	}
+	stmt.In = in
+	if err := p.nextToken(); err != nil {
+		return nil, err
+	}
+	if p.token.Type != TokenBlockBegin {
+		return nil, ErrUnexpectedToken(&p.token)
+	}
+	p.loops++
+	body, err := p.Stmt()
+	p.loops--
+	if err != nil {
+		return nil, err
+	}
+	mt := body.(MatchThenStmt)
+	stmt.Stmts, stmt.End = mt.Stmts, mt.End
+	return stmt, nil
+}
+
+// loopControl parses break or continue
+func (p *Parser) loopControl() (Stmt, error) {
+	token := p.token
+	pos := p.pos(&token)
+	p.useToken()
+	if p.loops == 0 {
+		return nil, pos.wrap(fmt.Errorf("%s is not in a for loop", token.Raw))
+	}
+	if err := p.nextToken(); err != nil {
+		return nil, err
+	}
+	if p.token.Type != TokenStmtEnd {
+		return nil, ErrUnexpectedToken(&p.token)
+	}
+	p.useToken()
+	if token.Type == TokenBreak {
+		return BreakStmt{Pos: pos}, nil
+	}
+	return ContinueStmt{Pos: pos}, nil
+}
+
+func (p *Parser) switchStmt() (Stmt, error) {
+	stmt := SwitchStmt{Pos: p.pos(&p.token)}
+	p.useToken()
+	if err := p.nextToken(); err != nil {
+		return nil, err
+	}
+	subject, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if subject == nil {
+		return nil, ErrUnexpectedToken(&p.token)
+	}
+	stmt.Subject = subject
+	if err := p.nextToken(); err != nil {
+		return nil, err
+	}
+	if p.token.Type != TokenBlockBegin {
+		return nil, ErrUnexpectedToken(&p.token)
+	}
+	p.useToken()
+	hasDefault := false
+	for {
+		if err := p.nextToken(); err != nil {
+			return nil, err
+		}
+		switch p.token.Type {
+		case TokenEOF:
+			return nil, ErrUnexpectedToken(&p.token)
+		case TokenBlockEnd:
+			stmt.End = p.pos(&p.token)
+			p.useToken()
+			return stmt, nil
+		case TokenCase, TokenDefault:
+		default:
+			p.fail(ErrUnexpectedToken(&p.token))
+			continue
+		}
+		c, err := p.caseClause()
+		if err != nil {
+			p.fail(err)
+			continue
+		}
+		if c.Default {
+			if hasDefault {
+				p.addError(c.Pos.wrap(errors.New("multiple defaults in switch")))
+				continue
+			}
+			hasDefault = true
+		}
+		stmt.Cases = append(stmt.Cases, c)
+	}
+}
+
+func (p *Parser) caseClause() (CaseClause, error) {
+	c := CaseClause{Pos: p.pos(&p.token), Default: p.token.Type == TokenDefault, Operator: EQ}
+	p.useToken()
+	if err := p.nextToken(); err != nil {
+		return c, err
+	}
+	if !c.Default {
+		if p.token.Type == TokenLike {
+			c.Operator = Like
+			p.useToken()
+			if err := p.nextToken(); err != nil {
+				return c, err
+			}
+		}
+		valuePos := p.pos(&p.token)
+		v, err := p.value()
+		if err != nil {
+			return c, err
+		}
+		if v == nil {
+			return c, ErrUnexpectedToken(&p.token)
+		}
+		c.Value = v
+		if c.Operator == Like {
+			if c.Regexes, err = compileRegexes(v); err != nil {
+				return c, valuePos.wrap(fmt.Errorf("invalid regex: %w", err))
+			}
+		}
+		if err := p.nextToken(); err != nil {
+			return c, err
+		}
+	}
+	if p.token.Type != TokenBlockBegin {
+		return c, ErrUnexpectedToken(&p.token)
+	}
+	body, err := p.Stmt()
+	if err != nil {
+		return c, err
+	}
+	mt := body.(MatchThenStmt)
+	c.Stmts, c.End = mt.Stmts, mt.End
+	return c, nil
+}
+
+// include parses the files matched by pattern, which is relative to the
+// directory of the including file
+func (p *Parser) include(pattern string) ([]Stmt, error) {
+	if !filepath.IsAbs(pattern) && p.File != "" {
+		pattern = filepath.Join(filepath.Dir(p.File), pattern)
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("include %s: %s", pattern, err.Error())
+	}
+	if len(files) == 0 && !strings.ContainsAny(pattern, "*?[") {
+		return nil, fmt.Errorf("include %s: file not found", pattern)
+	}
+	parents := append(p.parents[:len(p.parents):len(p.parents)], p.File)
+	ret := []Stmt{}
+	for _, file := range files {
+		for i, parent := range parents {
+			if sameFile(parent, file) {
+				return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(parents[i:], " -> "), file)
+			}
+		}
+		stmts, err := func() ([]Stmt, error) {
+			fs, err := os.Open(file)
+			if err != nil {
+				return nil, err
+			}
+			defer fs.Close()
+			sub := Parser{Lexer: NewLexer(), Reader: bufio.NewReader(fs), File: file, parents: parents}
+			return sub.Parse()
+		}()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, stmts...)
+	}
+	return ret, nil
+}
+
+func sameFile(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
+
+// elseStmt parses the optional else branch following a matched block
+func (p *Parser) elseStmt() (Stmt, error) {
+	if err := p.nextToken(); err != nil {
+		return nil, err
+	}
+	if p.token.Type != TokenElse {
+		return nil, nil
+	}
+	p.useToken()
+	if err := p.nextToken(); err != nil {
+		return nil, err
+	}
+	token := p.token
+	if token.Type == TokenBlockEnd {
+		return nil, ErrUnexpectedToken(&token)
+	}
+	stmt, err := p.Stmt()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := stmt.(MatchThenStmt); !ok {
+		return nil, ErrUnexpectedToken(&token)
+	}
+	return stmt, nil
+}
+
+func (p *Parser) condition() (Stmt, error) {
+	return p.logicStmt(Or, TokenOR, p.andCondition)
+}
+
+func (p *Parser) andCondition() (Stmt, error) {
+	return p.logicStmt(And, TokenAND, p.unaryCondition)
+}
+
+// logicStmt parses operands separated by the token typ, a single operand
+// is returned as it is
+func (p *Parser) logicStmt(op LogicOperator, typ int, operand func() (Stmt, error)) (Stmt, error) {
+	pos := p.pos(&p.token)
+	stmt, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	stmts := []Stmt{stmt}
+	for {
+		if err := p.nextToken(); err != nil {
+			return nil, err
+		}
+		if p.token.Type != typ {
+			break
+		}
+		if !isCondition(stmts[len(stmts)-1]) {
+			return nil, ErrUnexpectedToken(&p.token)
+		}
+		p.useToken()
+		if err := p.nextToken(); err != nil {
+			return nil, err
+		}
+		if stmt, err = operand(); err != nil {
+			return nil, err
+		}
+		if !isCondition(stmt) {
+			return nil, ErrUnexpectedToken(&p.token)
+		}
+		stmts = append(stmts, stmt)
+	}
+	if len(stmts) == 1 {
+		return stmts[0], nil
+	}
+	return LogicStmt{Pos: pos, Operator: op, Stmts: stmts}, nil
+}
+
+func (p *Parser) unaryCondition() (Stmt, error) {
+	switch p.token.Type {
+	case TokenNot:
+		pos := p.pos(&p.token)
+		p.useToken()
+		if err := p.nextToken(); err != nil {
+			return nil, err
+		}
+		stmt, err := p.unaryCondition()
+		if err != nil {
+			return nil, err
+		}
+		if !isCondition(stmt) {
+			return nil, ErrUnexpectedToken(&p.token)
+		}
+		return LogicStmt{Pos: pos, Operator: Not, Stmts: []Stmt{stmt}}, nil
+	case TokenParenBegin:
+		p.useToken()
+		if err := p.nextToken(); err != nil {
+			return nil, err
+		}
+		stmt, err := p.condition()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.nextToken(); err != nil {
+			return nil, err
+		}
+		if p.token.Type != TokenParenEnd || !isCondition(stmt) {
+			return nil, ErrUnexpectedToken(&p.token)
+		}
+		p.useToken()
+		return stmt, nil
+	default:
+		return p.smallStmt()
+	}
+}
+
+func isCondition(stmt Stmt) bool {
+	switch stmt.(type) {
+	case MatchStmt, FuncStmt, LogicStmt:
+		return true
+	}
+	return false
+}
+
 func (p *Parser) smallStmt() (Stmt, error) {
+	pos := p.pos(&p.token)
 	switch p.token.Type {
 	case TokenReturn:
 		p.useToken()
-		return ReturnStmt{}, nil
+		if err := p.nextToken(); err != nil {
+			return nil, err
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		return ReturnStmt{Pos: pos, Value: v}, nil
 	default:
-		v, err := p.nameOrValue()
+		v, err := p.value()
 		if err != nil {
 			return nil, err
 		}
@@ -133,12 +760,15 @@ func (p *Parser) smallStmt() (Stmt, error) {
 				if err := p.nextToken(); err != nil {
 					return nil, err
 				}
-				if right, err = p.nameOrValue(); err != nil {
+				if right, err = p.value(); err != nil {
 					return nil, err
 				}
-				return AssignmentStmt{Name: variable.Name, Value: right}, nil
-			case TokenStmtEnd, TokenBlockBegin:
-				return FuncStmt{Name: variable.Name, Args: variable.Args}, nil
+				if right == nil {
+					return nil, ErrUnexpectedToken(&p.token)
+				}
+				return AssignmentStmt{Pos: pos, Name: variable.Name, Value: right}, nil
+			case TokenStmtEnd, TokenBlockBegin, TokenAND, TokenOR, TokenParenEnd:
+				return FuncStmt{Pos: pos, Name: variable.Name, Args: variable.Args}, nil
 			}
 		}
 		var ok bool
@@ -148,35 +778,144 @@ func (p *Parser) smallStmt() (Stmt, error) {
 			if err := p.nextToken(); err != nil {
 				return nil, err
 			}
-			if right, err = p.nameOrValue(); err != nil {
+			rightPos := p.pos(&p.token)
+			if right, err = p.value(); err != nil {
 				return nil, err
 			}
-			return MatchStmt{Left: v, Operator: operator, Right: right}, nil
+			if right == nil {
+				return nil, ErrUnexpectedToken(&p.token)
+			}
+			match := MatchStmt{Pos: pos, Left: v, Operator: operator, Right: right}
+			if operator == Like || operator == NotLike {
+				if match.Regexes, err = compileRegexes(right); err != nil {
+					return nil, rightPos.wrap(fmt.Errorf("invalid regex: %w", err))
+				}
+			}
+			return match, nil
+		}
+		return nil, ErrUnexpectedToken(&p.token)
+	}
+}
+
+// value parses an arithmetic expression, it returns the operand alone if
+// there is no operator
+func (p *Parser) value() (Value, error) {
+	return p.arith(1)
+}
+
+// arith parses the operands joined by the operators of precedence not
+// lower than min, the operators of the same precedence are left
+// associative
+func (p *Parser) arith(min int) (Value, error) {
+	left, err := p.nameOrValue()
+	if left == nil || err != nil {
+		return left, err
+	}
+	for {
+		if err := p.nextToken(); err != nil {
+			return nil, err
+		}
+		op, ok := arithMap[p.token.Type]
+		if !ok || op.precedence() < min {
+			return left, nil
 		}
+		p.useToken()
+		if err := p.nextToken(); err != nil {
+			return nil, err
+		}
+		right, err := p.arith(op.precedence() + 1)
+		if err != nil {
+			return nil, err
+		}
+		if right == nil {
+			return nil, ErrUnexpectedToken(&p.token)
+		}
+		left = Expr{Operator: op, Left: left, Right: right}
+	}
+}
+
+// group parses the expression in parentheses, the closing ')' is left
+// for the caller to use like the token of a value
+func (p *Parser) group() (Value, error) {
+	p.useToken()
+	if err := p.nextToken(); err != nil {
+		return nil, err
+	}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
 		return nil, ErrUnexpectedToken(&p.token)
 	}
+	if err := p.nextToken(); err != nil {
+		return nil, err
+	}
+	if p.token.Type != TokenParenEnd {
+		return nil, ErrUnexpectedToken(&p.token)
+	}
+	return v, nil
+}
+
+// numberLiteral is the int or the float written as raw. A number written
+// with a leading zero like `007`, which is rather an id or a code, is kept
+// as the string it's written as, so is an int beyond 64 bits.
+func numberLiteral(raw []byte) Value {
+	digits := bytes.TrimPrefix(raw, []byte{'-'})
+	if len(digits) > 1 && digits[0] == '0' && digits[1] != '.' {
+		return Bytes(raw)
+	}
+	if i, err := strconv.ParseInt(string(raw), 10, 64); err == nil {
+		return Int(i)
+	}
+	if bytes.IndexByte(raw, '.') > -1 {
+		if f, err := strconv.ParseFloat(string(raw), 64); err == nil {
+			return Float(f)
+		}
+	}
+	return Bytes(raw)
 }
 
 func (p *Parser) nameOrValue() (Value, error) {
-	getValue := func() Value {
+	getValue := func() (Value, error) {
 		switch p.token.Type {
-		case TokenString, TokenInt, TokenFloat:
-			return Bytes(p.token.Raw)
+		case TokenInt, TokenFloat:
+			return numberLiteral(p.token.Raw), nil
+		case TokenString:
+			return Bytes(p.token.Raw), nil
+		case TokenDuration:
+			d, err := time.ParseDuration(string(p.token.Raw))
+			if err != nil {
+				return nil, p.pos(&p.token).wrap(err)
+			}
+			return Duration(d), nil
+		case TokenParenBegin:
+			return p.group()
+		case TokenTemplate:
+			return p.template(&p.token)
 		case TokenName:
-			return &Variable{Name: string(p.token.Raw)}
+			return &Variable{Name: string(p.token.Raw)}, nil
 		case TokenFalse:
-			return Bool(false)
+			return Bool(false), nil
 		case TokenTrue:
-			return Bool(true)
+			return Bool(true), nil
 		case TokenNull:
-			return Null{}
+			return Null{}, nil
 		default:
-			return nil
+			return nil, nil
 		}
 	}
-	ret := getValue()
-	if ret == nil {
-		return ret, nil
+	// item is a value in a list, where an operator is the string it's
+	// written as, like in `path == /`
+	item := func() (Value, error) {
+		if _, ok := arithMap[p.token.Type]; ok {
+			return Bytes([]byte(tokenMap[p.token.Type])), nil
+		}
+		return getValue()
+	}
+	ret, err := item()
+	if ret == nil || err != nil {
+		return ret, err
 	}
 	p.useToken()
 	va, isVar := ret.(*Variable)
@@ -190,7 +929,13 @@ func (p *Parser) nameOrValue() (Value, error) {
 			if err := p.nextToken(); err != nil {
 				return nil, err
 			}
-			n := getValue()
+			n, err := item()
+			if err != nil {
+				return nil, err
+			}
+			if n == nil {
+				return ret, ErrUnexpectedToken(&p.token)
+			}
 			if isVar && len(va.Args) > 0 {
 				p.useToken()
 				v := va.Args[len(va.Args)-1]
@@ -214,7 +959,10 @@ func (p *Parser) nameOrValue() (Value, error) {
 			}
 			return ret, ErrUnexpectedToken(&p.token)
 		case isVar:
-			v := getValue()
+			v, err := getValue()
+			if err != nil {
+				return nil, err
+			}
 			if v == nil {
 				return va, nil
 			}
@@ -226,15 +974,66 @@ func (p *Parser) nameOrValue() (Value, error) {
 	}
 }
 
+// template parses the expressions interpolated in a string
+func (p *Parser) template(t *Token) (Value, error) {
+	ret := Template{}
+	for _, part := range t.Parts {
+		if !part.Expr {
+			ret.Parts = append(ret.Parts, String(string(part.Raw)))
+			continue
+		}
+		sub := Parser{
+			Lexer:  &Lexer{b: make([]byte, 1), row: part.Row, col: part.Col},
+			Reader: bytes.NewReader(part.Raw),
+			File:   p.File,
+		}
+		if err := sub.nextToken(); err != nil {
+			return nil, err
+		}
+		v, err := sub.value()
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			return nil, ErrUnexpectedToken(&sub.token)
+		}
+		if err := sub.nextToken(); err != nil {
+			return nil, err
+		}
+		if sub.token.Type != TokenEOF {
+			return nil, ErrUnexpectedToken(&sub.token)
+		}
+		ret.Parts = append(ret.Parts, v)
+	}
+	return ret, nil
+}
+
+// Comments returns the comments met so far
+func (p *Parser) Comments() []Comment {
+	return p.comments
+}
+
+func (p *Parser) pos(t *Token) Pos {
+	return Pos{File: p.File, Row: t.Row, Col: t.Col}
+}
+
 func (p *Parser) nextToken() (err error) {
 	if p.token.Type != TokenEmpty {
 		return nil
 	}
+	if p.broken != nil {
+		return p.broken
+	}
 	if p.token, err = p.Lexer.Scan(p.Reader); err != nil {
+		var pe PosError
+		if !errors.As(err, &pe) {
+			p.broken = err
+		}
 		return err
 	}
 	if p.token.Type == TokenComment {
-		p.useToken()
+		p.comments = append(p.comments, Comment{Pos: p.pos(&p.token), Text: string(p.token.Raw)})
+		p.token = Token{Type: TokenEmpty}
 		return p.nextToken()
 	}
 	// fmt.Printf("token: %s\n", p.token.String())
@@ -245,5 +1044,6 @@ func (p *Parser) useToken(use ...func()) {
 	if len(use) > 0 {
 		use[0]()
 	}
+	p.last = p.token.Type
 	p.token = Token{Type: TokenEmpty}
 }
@@ -7,7 +7,14 @@ package ngin_test
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/dev-mockingbird/logf"
 	"github.com/dev-mockingbird/ngin"
@@ -16,7 +23,6 @@ import (
 
 func TestParse(t *testing.T) {
 	bs := bytes.NewBuffer([]byte(`
-# the whitespace is very important, please remember use it around any meaningful token
 {
 	var header response host path;
     header.request-id == null {
@@ -85,3 +91,557 @@ func TestParse(t *testing.T) {
 		t.Fatal("execute failed")
 	}
 }
+
+func TestParse_Logic(t *testing.T) {
+	cases := []struct {
+		cond   string
+		expect bool
+	}{
+		{"a == 1 && b == 2", true},
+		{"a == 1 && b == 3", false},
+		{"a == 2 || b == 2", true},
+		{"a == 2 || b == 3", false},
+		{"!a == 2", true},
+		{"!(a == 1 && b == 2)", false},
+		{"a == 2 || b == 2 && c == 3", true},
+		{"(a == 2 || b == 2) && c == 4", false},
+		{"a==1&&(b==3||c==3)", true},
+		{"a == 2 && miss", false},
+		{"a == 1 || miss", true},
+	}
+	for _, c := range cases {
+		p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewBufferString(c.cond + " { matched = true; }")}
+		stmts, err := p.Parse()
+		if err != nil {
+			t.Fatalf("%s: %s", c.cond, err.Error())
+		}
+		ctx := ngin.NewContext()
+		ctx.Declare("matched")
+		ctx.BindValue("a", ngin.Int(1))
+		ctx.BindValue("b", ngin.Int(2))
+		ctx.BindValue("c", ngin.Int(3))
+		// miss must never be called since the result is known in advance
+		ctx.BindFunc("miss", func(ctx *ngin.Context, args ...ngin.Value) (bool, error) {
+			return false, errors.New("miss called")
+		})
+		for _, s := range stmts {
+			if _, err := s.Execute(ctx); err != nil {
+				t.Fatalf("%s: %s", c.cond, err.Error())
+			}
+		}
+		if ctx.GetValue("matched").Bool() != c.expect {
+			t.Fatalf("%s: expect %v", c.cond, c.expect)
+		}
+	}
+}
+
+func TestParse_LogicError(t *testing.T) {
+	for _, src := range []string{"a = 1 && b == 2 {}", "a == 1 && return {}", "(a == 1 {}", "!a = 1 {}"} {
+		p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewBufferString(src)}
+		if _, err := p.Parse(); err == nil {
+			t.Fatalf("%s: expect error", src)
+		}
+	}
+}
+
+func TestParse_Else(t *testing.T) {
+	src := `
+	code == 200 {
+		result = ok;
+	} else code == 401 || code == 403 {
+		result = denied;
+	} else {
+		result = failed;
+		return;
+	}
+	after = true;
+	`
+	cases := []struct {
+		code   int
+		result string
+		after  bool
+	}{
+		{200, "ok", true},
+		{401, "denied", true},
+		{403, "denied", true},
+		{500, "failed", false},
+	}
+	for _, c := range cases {
+		p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewBufferString(src)}
+		stmts, err := p.Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := ngin.NewContext()
+		ctx.Declare("result", "after")
+		ctx.BindValue("code", ngin.Int(int64(c.code)))
+		for _, s := range stmts {
+			ok, err := s.Execute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				break
+			}
+		}
+		if ctx.GetValue("result").String() != c.result {
+			t.Fatalf("%d: expect result %s, got %s", c.code, c.result, ctx.GetValue("result").String())
+		}
+		if ctx.GetValue("after").Bool() != c.after {
+			t.Fatalf("%d: expect after %v", c.code, c.after)
+		}
+	}
+}
+
+func TestParse_ElseError(t *testing.T) {
+	for _, src := range []string{"a == 1 {} else a = 1;", "{} else {}", "a = 1; else {}", "a == 1 {} else {} else {}", "a == 1 { else }"} {
+		p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewBufferString(src)}
+		if _, err := p.Parse(); err == nil {
+			t.Fatalf("%s: expect error", src)
+		}
+	}
+}
+
+func TestParse_Include(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.ngin":           "a = 1;\ninclude routes/*.ngin;\ninclude \"last.ngin\";\n",
+		"routes/1.ngin":       "b = 2;\n",
+		"routes/2.ngin":       "include ../shared/c.ngin;\n",
+		"shared/c.ngin":       "c = 3;\n",
+		"last.ngin":           "d = 4;\n",
+		"cycle/a.ngin":        "include b.ngin;\n",
+		"cycle/b.ngin":        "include a.ngin;\n",
+		"broken/main.ngin":    "a = 1;\ninclude sub.ngin;\n",
+		"broken/sub.ngin":     "\n  a = = 1;\n",
+		"missing/main.ngin":   "\ninclude nothing.ngin;\n",
+		"emptyglob/main.ngin": "include nothing/*.ngin;\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stmts, err := ngin.ParseFile(filepath.Join(dir, "main.ngin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := ngin.NewContext()
+	for _, s := range stmts {
+		if _, err := s.Execute(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, name := range []string{"a", "b", "c", "d"} {
+		if ctx.GetValue(name).Int() != int64(i+1) {
+			t.Fatalf("%s should be %d", name, i+1)
+		}
+	}
+	if _, err := ngin.ParseFile(filepath.Join(dir, "emptyglob/main.ngin")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ngin.ParseFile(filepath.Join(dir, "cycle/a.ngin"))
+	if err == nil || !strings.Contains(err.Error(), "include cycle") {
+		t.Fatalf("expect cycle error, got %v", err)
+	}
+	_, err = ngin.ParseFile(filepath.Join(dir, "broken/main.ngin"))
+	var pe ngin.PosError
+	if !errors.As(err, &pe) || filepath.Base(pe.File) != "sub.ngin" || pe.Row != 2 || pe.Col != 7 {
+		t.Fatalf("expect error at sub.ngin:2:7, got %v", err)
+	}
+	_, err = ngin.ParseFile(filepath.Join(dir, "missing/main.ngin"))
+	if !errors.As(err, &pe) || filepath.Base(pe.File) != "main.ngin" || pe.Row != 2 || pe.Col != 1 {
+		t.Fatalf("expect error at main.ngin:2:1, got %v", err)
+	}
+}
+
+func TestParse_Template(t *testing.T) {
+	src := `
+	auth = "Bearer ${token}";
+	target = "/v2${path}?id=${header.id}";
+	key = "user:${upper header.id}:${missing-func}";
+	escaped = "\${token}";
+	`
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewBufferString(src)}
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := ngin.NewContext()
+	ctx.Declare("missing-func")
+	ctx.BindValue("token", ngin.String("xyz"))
+	ctx.BindValue("path", ngin.String("/users"))
+	ctx.BindValue("header.id", ngin.Int(12))
+	ctx.BindValuedFunc("upper", func(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+		return ngin.String(strings.ToUpper(args[0].String() + "a"))
+	})
+	for _, s := range stmts {
+		if _, err := s.Execute(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expect := map[string]string{
+		"auth":    "Bearer xyz",
+		"target":  "/v2/users?id=12",
+		"key":     "user:12A:",
+		"escaped": "${token}",
+	}
+	for name, value := range expect {
+		if v := ctx.GetValue(name).String(); v != value {
+			t.Fatalf("%s: expect %s, got %s", name, value, v)
+		}
+	}
+	for _, src := range []string{`a = "${}";`, `a = "${b;}";`, `a = "${ ( }";`, `a = "${b`} {
+		p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewBufferString(src)}
+		if _, err := p.Parse(); err == nil {
+			t.Fatalf("%s: expect error", src)
+		}
+	}
+}
+
+type recordLogger struct {
+	prefix string
+	logs   *[]string
+}
+
+func (l recordLogger) Prefix(prefix string) logf.Logger {
+	return recordLogger{prefix: l.prefix + prefix, logs: l.logs}
+}
+
+func (l recordLogger) Logf(level logf.Level, format string, v ...any) {
+	*l.logs = append(*l.logs, l.prefix+fmt.Sprintf(format, v...))
+}
+
+func TestParse_Position(t *testing.T) {
+	src := "a = 1;\nlisten 6000 {\n  a == 1 && b ~ x {\n    warn;\n    nothing;\n  }\n}\n"
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewBufferString(src), File: "test.ngin"}
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos := stmts[0].(ngin.AssignmentStmt).Pos; pos.Row != 1 || pos.Col != 1 || pos.File != "test.ngin" {
+		t.Fatalf("assignment at %s", pos)
+	}
+	listen := stmts[1].(ngin.MatchThenStmt)
+	if pos := listen.Match.(ngin.FuncStmt).Pos; pos.Row != 2 || pos.Col != 1 {
+		t.Fatalf("listen at %s", pos)
+	}
+	block := listen.Stmts[0].(ngin.MatchThenStmt)
+	logic := block.Match.(ngin.LogicStmt)
+	if block.Pos.Row != 3 || block.Pos.Col != 3 || logic.Pos != block.Pos {
+		t.Fatalf("block at %s, condition at %s", block.Pos, logic.Pos)
+	}
+	if pos := logic.Stmts[1].(ngin.MatchStmt).Pos; pos.Row != 3 || pos.Col != 13 {
+		t.Fatalf("match at %s", pos)
+	}
+	if pos := block.Stmts[1].(ngin.FuncStmt).Pos; pos.Row != 5 || pos.Col != 5 {
+		t.Fatalf("nothing at %s", pos)
+	}
+	var logs []string
+	ctx := ngin.NewContext()
+	ctx.SetLogger(recordLogger{logs: &logs})
+	ctx.BindFunc("listen", func(ctx *ngin.Context, args ...ngin.Value) (bool, error) {
+		return true, nil
+	})
+	ctx.BindFunc("warn", func(ctx *ngin.Context, args ...ngin.Value) (bool, error) {
+		ctx.Logger().Logf(logf.Warn, "be careful")
+		return true, nil
+	})
+	ctx.BindValue("b", ngin.String("x"))
+	for _, s := range stmts {
+		_, err = s.Execute(ctx)
+	}
+	var pe ngin.PosError
+	if !errors.As(err, &pe) || pe.Row != 5 || pe.Col != 5 || err.Error() != "func [nothing] not found at test.ngin:5:5" {
+		t.Fatalf("expect positioned error, got %v", err)
+	}
+	if len(logs) != 1 || logs[0] != "[test.ngin:4:5] be careful" {
+		t.Fatalf("logs: %v", logs)
+	}
+}
+
+func TestParse_Recover(t *testing.T) {
+	src := `a = 1;
+b = = 2;
+c = 3;
+d == 1 {
+    e = ;
+    f = 6;
+    g == {
+        h = "unterminated \q";
+    }
+    i = 9;
+}
+} j = 10;
+k == 1 {} else l = 1;
+m = 13;
+n = {`
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewBufferString(src), File: "broken.ngin"}
+	stmts, err := p.Parse()
+	var errs ngin.ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("expect error list, got %v", err)
+	}
+	expect := [][2]int{{2, 5}, {5, 9}, {7, 10}, {8, 28}, {12, 1}, {13, 16}, {15, 5}, {15, 6}}
+	if len(errs) != len(expect) {
+		t.Fatalf("expect %d errors, got %d:\n%s", len(expect), len(errs), err.Error())
+	}
+	for i, e := range errs {
+		var pe ngin.PosError
+		if !errors.As(e, &pe) || pe.File != "broken.ngin" || pe.Row != expect[i][0] || pe.Col != expect[i][1] {
+			t.Fatalf("error %d: %s", i, e.Error())
+		}
+	}
+	ctx := ngin.NewContext()
+	ctx.Declare("f", "i")
+	ctx.BindValue("d", ngin.Int(1))
+	for _, s := range stmts {
+		if _, err := s.Execute(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, value := range map[string]int64{"a": 1, "c": 3, "f": 6, "i": 9, "j": 10, "m": 13} {
+		if v := ctx.GetValue(name); v.Int() != value {
+			t.Fatalf("%s should be %d, got %s", name, value, v.String())
+		}
+	}
+}
+
+type failingReader struct{}
+
+var errRead = errors.New("read failed")
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errRead
+}
+
+func TestParse_ReaderError(t *testing.T) {
+	for _, src := range []string{"", "a = 1;\nb == 1 {\n    c = 3;\n", "d = \"unterminated"} {
+		p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: io.MultiReader(strings.NewReader(src), failingReader{})}
+		done := make(chan error, 1)
+		go func() {
+			_, err := p.Parse()
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			var errs ngin.ErrorList
+			if !errors.As(err, &errs) || len(errs) != 1 || !errors.Is(errs[0], errRead) {
+				t.Fatalf("%q: expect the read error only, got %v", src, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%q: parsing doesn't stop at the read error", src)
+		}
+	}
+	if _, err := ngin.ParseFile(t.TempDir()); err == nil {
+		t.Fatal("expect parsing a directory to fail")
+	}
+}
+
+func TestParse_Regex(t *testing.T) {
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewBufferString("path ~ ^/api | `(v1`;\npath ~ *;"), File: "regex.ngin"}
+	_, err := p.Parse()
+	var errs ngin.ErrorList
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("expect 2 errors, got %v", err)
+	}
+	for i, e := range errs {
+		var pe ngin.PosError
+		if !errors.As(e, &pe) || pe.Row != i+1 || pe.Col != 8 || !strings.Contains(e.Error(), "invalid regex") {
+			t.Fatalf("unexpected error: %s", e.Error())
+		}
+	}
+	p = ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewBufferString(`
+pattern = "^/v[0-9]+/";
+path ~ ^/api/ | pattern {
+    matched = true;
+}
+path !~ pattern {
+    unmatched = true;
+}
+`)}
+	stmts, err := p.Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if re := stmts[1].(ngin.MatchThenStmt).Match.(ngin.MatchStmt).Regexes; len(re) != 2 || re[0] == nil || re[1] != nil {
+		t.Fatalf("the literal pattern should be compiled, the variable shouldn't")
+	}
+	ctx := ngin.NewContext()
+	ctx.Declare("matched", "unmatched")
+	ctx.BindValue("path", ngin.String("/v2/users"))
+	for _, s := range stmts {
+		if _, err := s.Execute(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !ctx.GetValue("matched").Bool() || ctx.GetValue("unmatched").Bool() {
+		t.Fatalf("unexpected match result")
+	}
+}
+
+func TestParse_Def(t *testing.T) {
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewBufferString(`
+def greet name greeting {
+    return "${greeting} ${name}";
+}
+def a.b { }
+def twice x x { return; }
+`), File: "def.ngin"}
+	stmts, err := p.Parse()
+	var errs ngin.ErrorList
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("expect 2 errors, got %v", err)
+	}
+	if !strings.Contains(errs[0].Error(), "invalid function name a.b at def.ngin:5:5") || !strings.Contains(errs[1].Error(), "duplicate parameter x at def.ngin:6:13") {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if len(stmts) != 1 {
+		t.Fatalf("expect 1 statement, got %d", len(stmts))
+	}
+	def, ok := stmts[0].(ngin.DefStmt)
+	if !ok || def.Name != "greet" || len(def.Params) != 2 || def.Params[1] != "greeting" || def.End.Row != 4 {
+		t.Fatalf("unexpected def: %#v", stmts[0])
+	}
+	if ret, ok := def.Stmts[0].(ngin.ReturnStmt); !ok || ret.Value == nil {
+		t.Fatalf("expect a return with a value")
+	}
+}
+
+func TestParse_Switch(t *testing.T) {
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewBufferString(`
+switch method {
+    case GET | HEAD {
+        a = 1;
+    }
+    case ~ ^P {
+    }
+    default {
+    }
+}
+switch path { default {} default {} }
+switch path { case ~ ` + "`(`" + ` {} }
+switch path { a = 1; }
+`), File: "switch.ngin"}
+	stmts, err := p.Parse()
+	var errs ngin.ErrorList
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("expect 3 errors, got %v", err)
+	}
+	for i, expect := range []string{"multiple defaults in switch at switch.ngin:11:26", "invalid regex", "unexpected token"} {
+		if !strings.Contains(errs[i].Error(), expect) {
+			t.Fatalf("expect %s, got %s", expect, errs[i].Error())
+		}
+	}
+	s, ok := stmts[0].(ngin.SwitchStmt)
+	if !ok || len(s.Cases) != 3 || s.End.Row != 10 {
+		t.Fatalf("unexpected switch: %#v", stmts[0])
+	}
+	if c := s.Cases[0]; c.Operator != ngin.EQ || len(c.Value.Slice()) != 2 || len(c.Stmts) != 1 || c.End.Row != 5 {
+		t.Fatalf("unexpected case: %#v", c)
+	}
+	if c := s.Cases[1]; c.Operator != ngin.Like || len(c.Regexes) != 1 || c.Regexes[0] == nil {
+		t.Fatalf("unexpected case: %#v", c)
+	}
+	if !s.Cases[2].Default {
+		t.Fatal("expect the default case")
+	}
+}
+
+func TestParse_For(t *testing.T) {
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewBufferString(`
+for k, v in query {
+    v == "" {
+        continue;
+    }
+    break;
+}
+for item in list { }
+break;
+for a in b { def f { break; } }
+for a, a in b { }
+for a b { }
+`), File: "for.ngin"}
+	stmts, err := p.Parse()
+	var errs ngin.ErrorList
+	if !errors.As(err, &errs) || len(errs) != 4 {
+		t.Fatalf("expect 4 errors, got %v", err)
+	}
+	for i, expect := range []string{
+		"break is not in a for loop at for.ngin:9:1",
+		"break is not in a for loop at for.ngin:10:22",
+		"duplicate loop variable a at for.ngin:11:8",
+		"unexpected token [b]",
+	} {
+		if !strings.Contains(errs[i].Error(), expect) {
+			t.Fatalf("expect %s, got %s", expect, errs[i].Error())
+		}
+	}
+	f, ok := stmts[0].(ngin.ForStmt)
+	if !ok || f.Key != "k" || f.Item != "v" || f.In.(*ngin.Variable).Name != "query" || len(f.Stmts) != 2 || f.End.Row != 7 {
+		t.Fatalf("unexpected for: %#v", stmts[0])
+	}
+	if _, ok := f.Stmts[1].(ngin.BreakStmt); !ok {
+		t.Fatalf("expect break")
+	}
+	if f, ok := stmts[1].(ngin.ForStmt); !ok || f.Key != "" || f.Item != "item" {
+		t.Fatalf("unexpected for: %#v", stmts[1])
+	}
+}
+
+func TestParse_Arith(t *testing.T) {
+	p := ngin.Parser{Lexer: ngin.NewLexer(), Reader: bytes.NewBufferString(`
+a = 1 + b * 2 - c;
+count + 1 >= limit % 3;
+f (a + 1) b;
+path == / | *;
+a = 1 + ;
+`), File: "arith.ngin"}
+	stmts, err := p.Parse()
+	var errs ngin.ErrorList
+	if !errors.As(err, &errs) || len(errs) != 1 || !strings.Contains(errs[0].Error(), "unexpected token [;]") {
+		t.Fatalf("expect the unexpected token error, got %v", err)
+	}
+	a, ok := stmts[0].(ngin.AssignmentStmt)
+	if !ok {
+		t.Fatalf("unexpected statement: %#v", stmts[0])
+	}
+	// (1 + (b * 2)) - c
+	sub, ok := a.Value.(ngin.Expr)
+	if !ok || sub.Operator != ngin.Sub || sub.Right.(*ngin.Variable).Name != "c" {
+		t.Fatalf("unexpected expression: %#v", a.Value)
+	}
+	add, ok := sub.Left.(ngin.Expr)
+	if !ok || add.Operator != ngin.Add || add.Left.String() != "1" {
+		t.Fatalf("unexpected expression: %#v", sub.Left)
+	}
+	if mul, ok := add.Right.(ngin.Expr); !ok || mul.Operator != ngin.Mul {
+		t.Fatalf("unexpected expression: %#v", add.Right)
+	}
+	m, ok := stmts[1].(ngin.MatchStmt)
+	if !ok || m.Operator != ngin.GTE {
+		t.Fatalf("unexpected statement: %#v", stmts[1])
+	}
+	if l, ok := m.Left.(ngin.Expr); !ok || l.Operator != ngin.Add {
+		t.Fatalf("unexpected left: %#v", m.Left)
+	}
+	if r, ok := m.Right.(ngin.Expr); !ok || r.Operator != ngin.Mod {
+		t.Fatalf("unexpected right: %#v", m.Right)
+	}
+	f, ok := stmts[2].(ngin.FuncStmt)
+	if !ok || len(f.Args) != 2 {
+		t.Fatalf("unexpected call: %#v", stmts[2])
+	}
+	if _, ok := f.Args[0].(ngin.Expr); !ok {
+		t.Fatalf("expect the expression in parentheses, got %#v", f.Args[0])
+	}
+	// operators where a value is expected are strings
+	m, ok = stmts[3].(ngin.MatchStmt)
+	if s, isSlice := m.Right.(ngin.Slice); !ok || !isSlice || s[0].String() != "/" || s[1].String() != "*" {
+		t.Fatalf("unexpected statement: %#v", stmts[3])
+	}
+}
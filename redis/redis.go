@@ -2,6 +2,7 @@ package redis
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/dev-mockingbird/logf"
@@ -15,6 +16,9 @@ func Init(ctx *ngin.Context) {
 	ctx.BindFunc("config-redis", ConfigRedis)
 	ctx.BindFunc("redis-set", RedisSet)
 	ctx.BindValuedFunc("redis-get", RedisGet)
+	ctx.Describe("config-redis", "config-redis [addr] [db] [username] [password]\n\nconnects to redis, it must be called before the other redis functions")
+	ctx.Describe("redis-set", "redis-set key value [ttl]\n\nsets key to value, ttl is a duration like 30s or a number of seconds")
+	ctx.Describe("redis-get", "redis-get key\n\nreturns the value of key, null if there isn't one")
 }
 
 func ConfigRedis(ctx *ngin.Context, args ...ngin.Value) (bool, error) {
@@ -23,7 +27,11 @@ func ConfigRedis(ctx *ngin.Context, args ...ngin.Value) (bool, error) {
 		opt.Addr = args[0].WithContext(ctx).String()
 	}
 	if len(args) >= 2 {
-		opt.DB = int(args[1].WithContext(ctx).Int())
+		db, err := ngin.ToInt(args[1].WithContext(ctx))
+		if err != nil {
+			return false, fmt.Errorf("config-redis: db: %w", err)
+		}
+		opt.DB = int(db)
 	}
 	if len(args) >= 3 {
 		opt.Username = args[2].WithContext(ctx).String()
@@ -48,7 +56,11 @@ func RedisSet(ctx *ngin.Context, args ...ngin.Value) (bool, error) {
 	val := args[1].WithContext(ctx).String()
 	expire := time.Duration(0)
 	if len(args) >= 3 {
-		expire = time.Second * time.Duration(args[2].Int())
+		ttl, err := ngin.ToDuration(args[2].WithContext(ctx))
+		if err != nil {
+			return false, fmt.Errorf("redis-set: ttl: %w", err)
+		}
+		expire = ttl
 	}
 	err := redis_cli.Set(context.Background(), key, val, expire).Err()
 	if err != nil {
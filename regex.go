@@ -0,0 +1,94 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin
+
+import (
+	"container/list"
+	"regexp"
+	"sync"
+)
+
+// RegexCacheSize is how many regexes built when the script runs are kept
+// compiled, the least recently used ones are dropped beyond it
+const RegexCacheSize = 256
+
+var regexes = newRegexCache(RegexCacheSize)
+
+// regexCache keeps the compiled regexes, the invalid patterns too so they
+// aren't compiled again
+type regexCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type regexEntry struct {
+	pattern string
+	re      *regexp.Regexp
+	err     error
+}
+
+func newRegexCache(size int) *regexCache {
+	return &regexCache{size: size, order: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *regexCache) compile(pattern string) (*regexp.Regexp, error) {
+	c.mu.Lock()
+	if e, ok := c.items[pattern]; ok {
+		c.order.MoveToFront(e)
+		entry := e.Value.(*regexEntry)
+		c.mu.Unlock()
+		return entry.re, entry.err
+	}
+	c.mu.Unlock()
+	re, err := regexp.Compile(pattern)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[pattern]; !ok {
+		c.items[pattern] = c.order.PushFront(&regexEntry{pattern: pattern, re: re, err: err})
+		for c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.items, oldest.Value.(*regexEntry).pattern)
+		}
+	}
+	return re, err
+}
+
+// CompileRegex compiles pattern like the regexes built when the script
+// runs, sharing their cache, the functions taking a regex from the script
+// use it
+func CompileRegex(pattern string) (*regexp.Regexp, error) {
+	return regexes.compile(pattern)
+}
+
+func (c *regexCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// compileRegexes compiles the literal patterns of the right side of '~'
+// or '!~', the ones built when the script runs are left nil
+func compileRegexes(right Value) ([]*regexp.Regexp, error) {
+	items := []Value{right}
+	if s, ok := right.(Slice); ok {
+		items = s
+	}
+	ret := make([]*regexp.Regexp, len(items))
+	for i, item := range items {
+		switch item.(type) {
+		case str, bs, it, flt:
+			re, err := regexp.Compile(item.String())
+			if err != nil {
+				return nil, err
+			}
+			ret[i] = re
+		}
+	}
+	return ret, nil
+}
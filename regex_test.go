@@ -0,0 +1,97 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRegexCache(t *testing.T) {
+	c := newRegexCache(2)
+	a, err := c.compile("^a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := c.compile("^a"); again != a {
+		t.Fatal("the regex should be cached")
+	}
+	if _, err := c.compile("(b"); err == nil {
+		t.Fatal("expect error")
+	}
+	c.compile("^a")
+	c.compile("^c")
+	if c.len() != 2 {
+		t.Fatalf("expect 2 regexes cached, got %d", c.len())
+	}
+	if again, _ := c.compile("^a"); again != a {
+		t.Fatal("the recently used regex shouldn't be dropped")
+	}
+	if _, ok := c.items["(b"]; ok {
+		t.Fatal("the least recently used regex should be dropped")
+	}
+}
+
+func benchmarkMatch(b *testing.B, src string) {
+	p := Parser{Lexer: NewLexer(), Reader: strings.NewReader(src)}
+	stmts, err := p.Parse()
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := NewContext()
+	ctx.BindValue("path", String("/api/v1/users/12345"))
+	ctx.BindValue("pattern", String(`^/api/v[0-9]+/users/\d+$`))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := stmts[0].Execute(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkMatch_Literal runs a '~' with a pattern compiled by the parser
+func BenchmarkMatch_Literal(b *testing.B) {
+	benchmarkMatch(b, "path ~ `^/login$` | `^/api/v[0-9]+/users/\\d+$`;")
+}
+
+// BenchmarkMatch_Dynamic runs a '~' with a pattern from a variable, it's
+// compiled once through the cache
+func BenchmarkMatch_Dynamic(b *testing.B) {
+	benchmarkMatch(b, "path ~ pattern;")
+}
+
+// BenchmarkMatch_Compile is what every '~' cost before the patterns were
+// compiled ahead
+func BenchmarkMatch_Compile(b *testing.B) {
+	path := "/api/v1/users/12345"
+	for i := 0; i < b.N; i++ {
+		for _, pattern := range []string{`^/login$`, `^/api/v[0-9]+/users/\d+$`} {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if re.MatchString(path) {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkRegexCache(b *testing.B) {
+	c := newRegexCache(RegexCacheSize)
+	patterns := make([]string, RegexCacheSize)
+	for i := range patterns {
+		patterns[i] = fmt.Sprintf("^/api/v%d/", i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.compile(patterns[i%len(patterns)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package ngin
 
+import "strings"
+
 type Slice []Value
 
 func (s Slice) Slice() []Value {
@@ -14,24 +16,42 @@ func (s Slice) WithContext(ctx *Context) Value {
 	return ret
 }
 
-func (s Slice) Int() uint64 {
-	panic("can't get int value of slice")
+// Int is the int of the item of a list of one item, otherwise 0
+func (s Slice) Int() int64 {
+	if len(s) == 1 {
+		return s[0].Int()
+	}
+	return 0
 }
 
 func (s Slice) Float() float64 {
-	panic("can't get float value of slice")
+	if len(s) == 1 {
+		return s[0].Float()
+	}
+	return 0
 }
 
+// String joins the items with ", ", like the values of a repeated http
+// header are joined
 func (s Slice) String() string {
-	panic("can't get string value of slice")
+	items := make([]string, len(s))
+	for i, v := range s {
+		items[i] = v.String()
+	}
+	return strings.Join(items, ", ")
 }
 
 func (s Slice) Bytes() []byte {
-	panic("can't get bytes value of slice")
+	return []byte(s.String())
 }
 
+// Bool is the bool of the item of a list of one item, otherwise it tells
+// whether the list isn't empty
 func (s Slice) Bool() bool {
-	panic("can't get bool value of slice")
+	if len(s) == 1 {
+		return s[0].Bool()
+	}
+	return len(s) > 0
 }
 
 func (s Slice) Contain(val Value) bool {
@@ -48,16 +68,5 @@ func (s Slice) Value() Value {
 }
 
 func (s Slice) Compare(val Value) int {
-	r := val.Slice()
-	if len(s) > len(r) {
-		return 1
-	} else if len(s) < len(r) {
-		return -1
-	}
-	for i := 0; i < len(s); i++ {
-		if ret := s[i].Compare(r[i]); ret != 0 {
-			return ret
-		}
-	}
-	return 0
+	return compare(s, val)
 }
@@ -6,14 +6,56 @@ import (
 	"regexp"
 )
 
+// Stmt is a statement of the script. Execute compiles the statement again
+// at each call and runs it, it's kept for the hosts running the statements
+// one by one; Compile the statements once to run them many times, as the
+// listen package and the ngin command do.
 type Stmt interface {
 	Execute(ctx *Context) (bool, error)
 }
 
-type ReturnStmt struct{}
+// execute compiles s in the frame given by the host and runs it
+func execute(s Stmt, ctx *Context) (bool, error) {
+	c := compiler{}
+	return run(ctx, c.stmts([]Stmt{s}))
+}
 
-func (ReturnStmt) Execute(ctx *Context) (bool, error) {
-	return false, nil
+// Pos is where a statement begins in the script
+type Pos struct {
+	File string
+	Row  int
+	Col  int
+}
+
+func (p Pos) String() string {
+	if p.File != "" {
+		return fmt.Sprintf("%s:%d:%d", p.File, p.Row, p.Col)
+	}
+	return fmt.Sprintf("%d:%d", p.Row, p.Col)
+}
+
+// wrap attaches the position to err, unless err already tells where it
+// happened
+func (p Pos) wrap(err error) error {
+	if err == nil || p.Row == 0 {
+		return err
+	}
+	var pe PosError
+	if errors.As(err, &pe) {
+		return err
+	}
+	return PosError{File: p.File, Row: p.Row, Col: p.Col, err: err}
+}
+
+// ReturnStmt stops the running block. In a function defined by def,
+// Value is the result of the function if there is one.
+type ReturnStmt struct {
+	Pos   Pos
+	Value Value
+}
+
+func (r ReturnStmt) Execute(ctx *Context) (bool, error) {
+	return execute(r, ctx)
 }
 
 type Operator int
@@ -34,22 +76,33 @@ type ValueAble interface {
 }
 
 type MatchStmt struct {
+	Pos         Pos
 	Left, Right Value
 	Operator    Operator
+	// Regexes are the patterns of '~' and '!~' compiled by the parser, one
+	// for each item on the right, nil for the ones built when the script
+	// runs, which are compiled through a cache
+	Regexes []*regexp.Regexp
 }
 
 func (m MatchStmt) Execute(ctx *Context) (bool, error) {
-	left := m.Left.WithContext(ctx)
-	right := m.Right.WithContext(ctx)
+	return execute(m, ctx)
+}
+
+// compare compares the values evaluated in the context. '==' and '!='
+// test whether a value is one of a list on the right, two lists are
+// compared as a whole.
+func (m MatchStmt) compare(left, right Value) (bool, error) {
+	_, leftList := left.(Slice)
 	switch m.Operator {
 	case EQ:
-		if s, ok := right.(Slice); ok {
+		if s, ok := right.(Slice); ok && !leftList {
 			return s.Contain(left), nil
 		}
 		r := left.Compare(right)
 		return r == 0, nil
 	case NEQ:
-		if s, ok := right.(Slice); ok {
+		if s, ok := right.(Slice); ok && !leftList {
 			return !s.Contain(left), nil
 		}
 		r := left.Compare(right)
@@ -67,68 +120,173 @@ func (m MatchStmt) Execute(ctx *Context) (bool, error) {
 		r := left.Compare(right)
 		return r < 0, nil
 	case Like:
-		like := func(l, r string) (bool, error) {
-			re, err := regexp.Compile(r)
-			if err != nil {
-				return false, err
-			}
-			return re.MatchString(l), nil
-		}
-		if s, ok := right.(Slice); ok {
-			for _, item := range s {
-				if ok, err := like(left.String(), item.String()); err != nil || ok {
-					return ok, err
-				}
-			}
-			return false, nil
-		}
-		return like(left.String(), right.String())
+		return m.like(left, right)
 	case NotLike:
-		like := func(l, r string) (bool, error) {
-			re, err := regexp.Compile(r)
-			if err != nil {
+		ok, err := m.like(left, right)
+		return !ok && err == nil, err
+	default:
+		return false, errors.New("not supported operator")
+	}
+}
+
+// like reports whether left matches one of the patterns on the right
+func (m MatchStmt) like(left, right Value) (bool, error) {
+	items := []Value{right}
+	if s, ok := right.(Slice); ok {
+		items = s
+	}
+	l := left.String()
+	for i, item := range items {
+		var re *regexp.Regexp
+		if i < len(m.Regexes) {
+			re = m.Regexes[i]
+		}
+		if re == nil {
+			var err error
+			if re, err = regexes.compile(item.String()); err != nil {
 				return false, err
 			}
-			return re.MatchString(l), nil
 		}
-		if s, ok := right.(Slice); ok {
-			for _, item := range s {
-				if ok, err := like(left.String(), item.String()); err != nil || ok {
-					return !ok, err
-				}
-			}
+		if re.MatchString(l) {
 			return true, nil
 		}
-		ok, err := like(left.String(), right.String())
-		return !ok, err
-	default:
-		return false, errors.New("not supported operator")
 	}
+	return false, nil
+}
+
+type LogicOperator int
+
+const (
+	And LogicOperator = iota
+	Or
+	Not
+)
+
+// LogicStmt combines conditions with '&&', '||' or negates one with '!'.
+// The operands are evaluated from left to right and the evaluation stops
+// as soon as the result is known.
+type LogicStmt struct {
+	Pos      Pos
+	Operator LogicOperator
+	Stmts    []Stmt
+}
+
+func (l LogicStmt) Execute(ctx *Context) (bool, error) {
+	return execute(l, ctx)
 }
 
 type AssignmentStmt struct {
+	Pos   Pos
 	Name  string
 	Value Value
 }
 
 func (a AssignmentStmt) Execute(ctx *Context) (bool, error) {
-	if v, ok := a.Value.(*Variable); ok {
-		v.Context = ctx
-	}
-	ctx.BindValue(a.Name, a.Value.Value())
-	return true, nil
+	return execute(a, ctx)
 }
 
 type FuncStmt struct {
+	Pos  Pos
 	Name string
 	Args []Value
 }
 
 func (f FuncStmt) Execute(ctx *Context) (bool, error) {
-	if funk := ctx.GetFunc(f.Name); funk != nil {
-		return funk(ctx, f.Args...)
-	}
-	return false, fmt.Errorf("func [%s] not found", f.Name)
+	return execute(f, ctx)
+}
+
+// IncludeStmt holds the statements of the files matched by an include
+// directive, they are executed as if they were written in place of it
+type IncludeStmt struct {
+	Pos   Pos
+	Path  string
+	Stmts []Stmt
+}
+
+func (inc IncludeStmt) Execute(ctx *Context) (bool, error) {
+	return execute(inc, ctx)
+}
+
+// DefStmt defines the function Name, which can be called like the bound
+// functions, as a statement or for its value. A call runs Stmts in a
+// frame of its own derived from the caller's, where Params are bound to
+// the arguments. Returning false makes a call used as a condition fail,
+// the result of a call used as a statement is ignored. The function is
+// bound when its block begins, so it can be called before the def. End is
+// where the closing '}' is.
+type DefStmt struct {
+	Pos    Pos
+	End    Pos
+	Name   string
+	Params []string
+	Stmts  []Stmt
+}
+
+func (d DefStmt) Execute(ctx *Context) (bool, error) {
+	return execute(d, ctx)
+}
+
+// SwitchStmt evaluates Subject once then runs the block of the first case
+// matching it, or the default case if none does
+type SwitchStmt struct {
+	Pos     Pos
+	End     Pos
+	Subject Value
+	Cases   []CaseClause
+}
+
+// CaseClause is a case of a SwitchStmt. Operator is EQ for `case a | b`,
+// which matches a subject equal to one of the values, or Like for
+// `case ~ regex | regex`. Regexes are the literal patterns compiled. End
+// is where the closing '}' of the block is.
+type CaseClause struct {
+	Pos      Pos
+	End      Pos
+	Default  bool
+	Operator Operator
+	Value    Value
+	Regexes  []*regexp.Regexp
+	Stmts    []Stmt
+}
+
+func (s SwitchStmt) Execute(ctx *Context) (bool, error) {
+	return execute(s, ctx)
+}
+
+// ForStmt runs Stmts for each item of In: the items of a list, or the
+// attributes of a complex value in the order they're set. Item is bound to
+// the item and Key, if set, to its index or to the name of the
+// attribute, in a frame of their own for each iteration. End is where the
+// closing '}' is.
+type ForStmt struct {
+	Pos   Pos
+	End   Pos
+	Key   string
+	Item  string
+	In    Value
+	Stmts []Stmt
+}
+
+func (f ForStmt) Execute(ctx *Context) (bool, error) {
+	return execute(f, ctx)
+}
+
+// BreakStmt ends the enclosing for loop
+type BreakStmt struct {
+	Pos Pos
+}
+
+func (b BreakStmt) Execute(ctx *Context) (bool, error) {
+	return execute(b, ctx)
+}
+
+// ContinueStmt goes on with the next iteration of the enclosing for loop
+type ContinueStmt struct {
+	Pos Pos
+}
+
+func (c ContinueStmt) Execute(ctx *Context) (bool, error) {
+	return execute(c, ctx)
 }
 
 type EmptyStmt struct{}
@@ -137,24 +295,18 @@ func (EmptyStmt) Execute(ctx *Context) (bool, error) {
 	return true, nil
 }
 
+// MatchThenStmt executes Stmts in a sub context when Match is satisfied,
+// otherwise Else is executed if there is one. Else is another
+// MatchThenStmt, its Match is an EmptyStmt for a plain `else { }`. End is
+// where the closing '}' is.
 type MatchThenStmt struct {
+	Pos   Pos
+	End   Pos
 	Match Stmt
 	Stmts []Stmt
+	Else  Stmt
 }
 
 func (mt MatchThenStmt) Execute(ctx *Context) (bool, error) {
-	ctx.stmts = mt.Stmts
-	matched, err := mt.Match.Execute(ctx)
-	if err != nil || !matched {
-		return true, err
-	}
-	ctx.stmts = nil
-	subCtx := ctx.Folk()
-	for _, s := range mt.Stmts {
-		con, err := s.Execute(subCtx)
-		if !con || err != nil {
-			return con, err
-		}
-	}
-	return true, nil
+	return execute(mt, ctx)
 }
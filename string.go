@@ -1,7 +1,6 @@
 package ngin
 
 import (
-	"strconv"
 	"strings"
 )
 
@@ -17,12 +16,10 @@ func (str str) WithContext(*Context) Value {
 	return str
 }
 
-func (str str) Int() uint64 {
-	ret, err := strconv.ParseUint(str.content, 10, 64)
-	if err != nil {
-		panic(err)
-	}
-	return ret
+// Int is 0 if the string isn't written as a number, see ToInt
+func (str str) Int() int64 {
+	i, _, _, _ := parseNumber(str.content)
+	return i
 }
 
 func (str str) Bool() bool {
@@ -30,11 +27,8 @@ func (str str) Bool() bool {
 }
 
 func (str str) Float() float64 {
-	ret, err := strconv.ParseFloat(str.content, 64)
-	if err != nil {
-		panic(err)
-	}
-	return ret
+	_, f, _, _ := parseNumber(str.content)
+	return f
 }
 
 func (str str) String() string {
@@ -50,7 +44,7 @@ func (str str) Slice() []Value {
 }
 
 func (str str) Compare(val Value) int {
-	return strings.Compare(str.content, val.String())
+	return compare(str, val)
 }
 
 func (str str) Value() Value {
@@ -0,0 +1,219 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package strings
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dev-mockingbird/ngin"
+)
+
+func Init(ctx *ngin.Context) {
+	ctx.BindValuedFunc("lower", Lower)
+	ctx.BindValuedFunc("upper", Upper)
+	ctx.BindValuedFunc("trim", Trim)
+	ctx.BindValuedFunc("trim-prefix", TrimPrefix)
+	ctx.BindValuedFunc("trim-suffix", TrimSuffix)
+	ctx.BindValuedFunc("replace", Replace)
+	ctx.BindValuedFunc("regex-replace", RegexReplace)
+	ctx.BindValuedFunc("split", Split)
+	ctx.BindValuedFunc("join", Join)
+	ctx.BindValuedFunc("substr", Substr)
+	ctx.BindValuedFunc("length", Length)
+	ctx.BindValuedFunc("starts-with", StartsWith)
+	ctx.BindValuedFunc("ends-with", EndsWith)
+	ctx.BindValuedFunc("contains", Contains)
+	// the predicates are conditions too, like `starts-with path /api { }`
+	ctx.BindFunc("starts-with", ngin.Condition(StartsWith))
+	ctx.BindFunc("ends-with", ngin.Condition(EndsWith))
+	ctx.BindFunc("contains", ngin.Condition(Contains))
+	ctx.Describe("lower", "lower value\n\nreturns value in lower case")
+	ctx.Describe("upper", "upper value\n\nreturns value in upper case")
+	ctx.Describe("trim", "trim value [chars]\n\nremoves the leading and trailing whitespace of value, or the chars if given")
+	ctx.Describe("trim-prefix", "trim-prefix value prefix\n\nremoves prefix from the beginning of value if it's there")
+	ctx.Describe("trim-suffix", "trim-suffix value suffix\n\nremoves suffix from the end of value if it's there")
+	ctx.Describe("replace", "replace value old new\n\nreplaces every old in value with new")
+	ctx.Describe("regex-replace", "regex-replace value regex replacement\n\nreplaces the matches of regex in value with replacement, where $1 or ${name} is the text of a group, write \\${name} in a double quoted string or use a raw `...` string since ${name} is interpolated there")
+	ctx.Describe("split", "split value separator [count]\n\nsplits value around separator into a list, at most count items if given")
+	ctx.Describe("join", "join list separator\n\njoins the items of list with separator")
+	ctx.Describe("substr", "substr value start [length]\n\nreturns the characters of value from start, counted from the end if it's negative, up to length characters")
+	ctx.Describe("length", "length value\n\nreturns the number of characters of value, or of items of a list")
+	ctx.Describe("starts-with", "starts-with value prefix\n\nreports whether value begins with prefix")
+	ctx.Describe("ends-with", "ends-with value suffix\n\nreports whether value ends with suffix")
+	ctx.Describe("contains", "contains value sub\n\nreports whether sub is in value")
+}
+
+func Lower(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "lower", args, 1)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	return ngin.String(strings.ToLower(vs[0].String()))
+}
+
+func Upper(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "upper", args, 1)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	return ngin.String(strings.ToUpper(vs[0].String()))
+}
+
+func Trim(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "trim", args, 1)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	if len(vs) > 1 {
+		return ngin.String(strings.Trim(vs[0].String(), vs[1].String()))
+	}
+	return ngin.String(strings.TrimSpace(vs[0].String()))
+}
+
+func TrimPrefix(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "trim-prefix", args, 2)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	return ngin.String(strings.TrimPrefix(vs[0].String(), vs[1].String()))
+}
+
+func TrimSuffix(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "trim-suffix", args, 2)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	return ngin.String(strings.TrimSuffix(vs[0].String(), vs[1].String()))
+}
+
+func Replace(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "replace", args, 3)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	return ngin.String(strings.ReplaceAll(vs[0].String(), vs[1].String(), vs[2].String()))
+}
+
+func RegexReplace(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "regex-replace", args, 3)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	re, err := ngin.CompileRegex(vs[1].String())
+	if err != nil {
+		return ngin.Fail(fmt.Errorf("regex-replace: %w", err))
+	}
+	return ngin.String(re.ReplaceAllString(vs[0].String(), vs[2].String()))
+}
+
+func Split(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "split", args, 2)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	n := -1
+	if len(vs) > 2 {
+		count, err := ngin.ToInt(vs[2])
+		if err != nil {
+			return ngin.Fail(fmt.Errorf("split: count: %w", err))
+		}
+		n = int(count)
+	}
+	parts := strings.SplitN(vs[0].String(), vs[1].String(), n)
+	ret := make(ngin.Slice, len(parts))
+	for i, part := range parts {
+		ret[i] = ngin.String(part)
+	}
+	return ret
+}
+
+func Join(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "join", args, 2)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	items := vs[0].Slice()
+	parts := make([]string, len(items))
+	for i, item := range items {
+		parts[i] = item.String()
+	}
+	return ngin.String(strings.Join(parts, vs[1].String()))
+}
+
+func Substr(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "substr", args, 2)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	runes := []rune(vs[0].String())
+	start, err := ngin.ToInt(vs[1])
+	if err != nil {
+		return ngin.Fail(fmt.Errorf("substr: start: %w", err))
+	}
+	if start < 0 {
+		start += int64(len(runes))
+	}
+	start = clamp(start, len(runes))
+	end := int64(len(runes))
+	if len(vs) > 2 {
+		length, err := ngin.ToInt(vs[2])
+		if err != nil {
+			return ngin.Fail(fmt.Errorf("substr: length: %w", err))
+		}
+		if length < 0 {
+			return ngin.Fail(fmt.Errorf("substr: negative length %d", length))
+		}
+		end = clamp(start+length, len(runes))
+	}
+	return ngin.String(string(runes[start:end]))
+}
+
+func Length(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "length", args, 1)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	if s, ok := vs[0].(ngin.Slice); ok {
+		return ngin.Int(int64(len(s)))
+	}
+	return ngin.Int(int64(utf8.RuneCountInString(vs[0].String())))
+}
+
+func StartsWith(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "starts-with", args, 2)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	return ngin.Bool(strings.HasPrefix(vs[0].String(), vs[1].String()))
+}
+
+func EndsWith(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "ends-with", args, 2)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	return ngin.Bool(strings.HasSuffix(vs[0].String(), vs[1].String()))
+}
+
+func Contains(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	vs, err := ngin.EvalArgs(ctx, "contains", args, 2)
+	if err != nil {
+		return ngin.Fail(err)
+	}
+	return ngin.Bool(strings.Contains(vs[0].String(), vs[1].String()))
+}
+
+func clamp(i int64, max int) int64 {
+	switch {
+	case i < 0:
+		return 0
+	case i > int64(max):
+		return int64(max)
+	}
+	return i
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package strings_test
+
+import (
+	"testing"
+
+	"github.com/dev-mockingbird/ngin"
+	"github.com/dev-mockingbird/ngin/ngintest"
+	ngstrings "github.com/dev-mockingbird/ngin/strings"
+)
+
+func TestStrings(t *testing.T) {
+	ctx, err := ngintest.Run(t, `
+path = trim-prefix /api/v1/users /api;
+host = lower (trim " Hello.COM ");
+method = upper get;
+stripped = trim "--x--" -;
+token = substr "Bearer abc.def" 7;
+parts = split (substr "Bearer abc.def" 7) .;
+pair = split "a=b=c" "=" 2;
+joined = join (split "a,b,c" ",") " | ";
+version = regex-replace /v12/users "^/v(\\d+)/(?P<rest>.*)" "\${rest}@$1";
+raw = regex-replace /v12/users "^/v(\\d+)/(?P<rest>.*)" `+"`${rest}@$1`"+`;
+dashes = replace a.b.c . -;
+size = length héllo;
+count = length parts;
+tail = substr héllo -3 2;
+flag = starts-with path /v1;
+starts-with path /v1 && contains host hello && !ends-with host .org {
+    matched = true;
+}
+`, ngstrings.Init, "path", "host", "method", "stripped", "token", "parts", "pair", "joined", "version", "raw", "dashes", "size", "count", "tail", "flag", "matched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expect := range map[string]string{
+		"path":     "/v1/users",
+		"host":     "hello.com",
+		"method":   "GET",
+		"stripped": "x",
+		"token":    "abc.def",
+		"parts":    "abc, def",
+		"pair":     "a, b=c",
+		"joined":   "a | b | c",
+		"version":  "users@12",
+		"raw":      "users@12",
+		"dashes":   "a-b-c",
+		"size":     "5",
+		"count":    "2",
+		"tail":     "ll",
+		"flag":     "true",
+		"matched":  "true",
+	} {
+		if v := ctx.GetValue(name).String(); v != expect {
+			t.Fatalf("%s: expect %s, got %s", name, expect, v)
+		}
+	}
+	if parts, ok := ctx.GetValue("parts").(ngin.Slice); !ok || len(parts) != 2 {
+		t.Fatal("expect split to give a list")
+	}
+}
+
+func TestStrings_Fail(t *testing.T) {
+	for src, expect := range map[string]string{
+		`none = lower;`:                 "lower: too few arguments, got 0 at 1, 1",
+		`none = regex-replace a "(" b;`: "regex-replace: error parsing regexp: missing closing ): `(` at 1, 1",
+		`none = substr abc x;`:          "substr: start: x isn't a number at 1, 1",
+		`none = upper (1 / 0);`:         "division by zero at 1, 1",
+		`starts-with (1 % 0) x { }`:     "division by zero at 1, 1",
+	} {
+		ctx, err := ngintest.Run(t, src, ngstrings.Init, "none")
+		if err == nil || err.Error() != expect {
+			t.Fatalf("%s: expect %s, got %v", src, expect, err)
+		}
+		if _, ok := ctx.GetValue("none").(ngin.Null); !ok {
+			t.Fatalf("%s: a failed value shouldn't be assigned", src)
+		}
+	}
+}
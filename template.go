@@ -0,0 +1,63 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin
+
+import "strings"
+
+// Template is an interpolated string like "Bearer ${token}", its parts are
+// evaluated and joined each time its value is taken. It fails if one of
+// the parts fails.
+type Template struct {
+	Parts []Value
+}
+
+func (t Template) WithContext(ctx *Context) Value {
+	parts := make([]Value, len(t.Parts))
+	for i, part := range t.Parts {
+		parts[i] = part.WithContext(ctx)
+	}
+	return Template{Parts: parts}
+}
+
+func (t Template) Value() Value {
+	var sb strings.Builder
+	for _, part := range t.Parts {
+		v := part.Value()
+		if Err(v) != nil {
+			return v
+		}
+		sb.WriteString(v.String())
+	}
+	return String(sb.String())
+}
+
+func (t Template) Int() int64 {
+	return t.Value().Int()
+}
+
+func (t Template) Float() float64 {
+	return t.Value().Float()
+}
+
+func (t Template) String() string {
+	return t.Value().String()
+}
+
+func (t Template) Bytes() []byte {
+	return t.Value().Bytes()
+}
+
+func (t Template) Bool() bool {
+	return t.Value().Bool()
+}
+
+func (t Template) Slice() []Value {
+	return []Value{t}
+}
+
+func (t Template) Compare(v Value) int {
+	return t.Value().Compare(v)
+}
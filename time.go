@@ -0,0 +1,146 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// tm is a point in time, it's written as RFC 3339 like
+// 2006-01-02T15:04:05Z07:00 and converts to a number as the seconds since
+// the unix epoch
+type tm struct {
+	value time.Time
+}
+
+func Time(value time.Time) Value {
+	return tm{value: value}
+}
+
+func (t tm) WithContext(*Context) Value {
+	return t
+}
+
+func (t tm) Int() int64 {
+	return t.value.Unix()
+}
+
+func (t tm) Float() float64 {
+	return float64(t.value.Unix()) + float64(t.value.Nanosecond())/float64(time.Second)
+}
+
+func (t tm) String() string {
+	return t.value.Format(time.RFC3339Nano)
+}
+
+func (t tm) Bytes() []byte {
+	return []byte(t.String())
+}
+
+func (t tm) Bool() bool {
+	return !t.value.IsZero()
+}
+
+func (t tm) Compare(val Value) int {
+	return compare(t, val)
+}
+
+func (t tm) Slice() []Value {
+	return []Value{t}
+}
+
+func (t tm) Value() Value {
+	return t
+}
+
+// ToTime converts v to a time: a number is the seconds since the unix
+// epoch and a string is parsed as RFC 3339. It fails for anything else.
+func ToTime(v Value) (time.Time, error) {
+	v = v.Value()
+	if err := Err(v); err != nil {
+		return time.Time{}, err
+	}
+	if t, ok := v.(tm); ok {
+		return t.value, nil
+	}
+	if i, f, isInt, ok := number(v); ok {
+		if isInt {
+			return time.Unix(i, 0), nil
+		}
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+	}
+	switch v.(type) {
+	case str, bs:
+		if t, err := time.Parse(time.RFC3339Nano, v.String()); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s isn't a time", literal(v))
+}
+
+// temporal computes an expression with a time or a duration operand, ok is
+// false if there's none. A duration is added to or subtracted from a time
+// or another duration, two times subtract to a duration, a duration is
+// multiplied or divided by a number, and divided by another duration to
+// their ratio.
+func temporal(op ArithOperator, left, right Value) (v Value, ok bool) {
+	lt, ltm := left.(tm)
+	rt, rtm := right.(tm)
+	ld, ldur := left.(dur)
+	rd, rdur := right.(dur)
+	if !ltm && !rtm && !ldur && !rdur {
+		return nil, false
+	}
+	switch {
+	case op == Add && ltm && rdur:
+		return Time(lt.value.Add(rd.value)), true
+	case op == Add && ldur && rtm:
+		return Time(rt.value.Add(ld.value)), true
+	case op == Sub && ltm && rdur:
+		return Time(lt.value.Add(-rd.value)), true
+	case op == Sub && ltm && rtm:
+		return Duration(lt.value.Sub(rt.value)), true
+	case op == Add && ldur && rdur:
+		return Duration(ld.value + rd.value), true
+	case op == Sub && ldur && rdur:
+		return Duration(ld.value - rd.value), true
+	case (op == Div || op == Mod) && ldur && rdur:
+		if rd.value == 0 {
+			return Fail(errDivisionByZero), true
+		}
+		if op == Mod {
+			return Duration(ld.value % rd.value), true
+		}
+		return Float(float64(ld.value) / float64(rd.value)), true
+	case op == Mul && ldur:
+		if _, f, _, ok := number(right); ok {
+			return Duration(time.Duration(float64(ld.value) * f)), true
+		}
+	case op == Mul && rdur:
+		if _, f, _, ok := number(left); ok {
+			return Duration(time.Duration(f * float64(rd.value))), true
+		}
+	case op == Div && ldur:
+		if _, f, _, ok := number(right); ok {
+			if f == 0 {
+				return Fail(errDivisionByZero), true
+			}
+			return Duration(time.Duration(float64(ld.value) / f)), true
+		}
+	}
+	if op == Add {
+		if _, _, _, ok := number(left); !ok {
+			return String(left.String() + right.String()), true
+		}
+		if _, _, _, ok := number(right); !ok {
+			return String(left.String() + right.String()), true
+		}
+	}
+	return Fail(fmt.Errorf("can't compute %s %s %s", literal(left), op.String(), literal(right))), true
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package time
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dev-mockingbird/ngin"
+)
+
+// layouts are the names of the common layouts, any other layout is a go
+// layout like 2006-01-02
+var layouts = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"http":        http.TimeFormat,
+	"date":        "2006-01-02",
+	"datetime":    "2006-01-02 15:04:05",
+}
+
+func Init(ctx *ngin.Context) {
+	ctx.BindValuedFunc("now", Now)
+	ctx.BindValuedFunc("parse-time", ParseTime)
+	ctx.BindValuedFunc("format-time", FormatTime)
+	ctx.BindValuedFunc("parse-duration", ParseDuration)
+	ctx.BindValuedFunc("unix", Unix)
+	ctx.Describe("now", "now\n\nreturns the current time")
+	ctx.Describe("parse-time", "parse-time value [layout]\n\nparses value as a time written in layout, which is rfc3339 (the default), rfc3339nano, http, date, datetime or a go layout like 2006-01-02; a number is the seconds since the unix epoch")
+	ctx.Describe("format-time", "format-time time [layout]\n\nwrites time in layout, see parse-time, the http layout is in GMT like the Date header")
+	ctx.Describe("parse-duration", "parse-duration value\n\nparses value as a duration like 30s or 1h30m, a number is a number of seconds")
+	ctx.Describe("unix", "unix [time]\n\nreturns the seconds since the unix epoch of time, now by default")
+}
+
+func Now(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	return ngin.Time(time.Now())
+}
+
+func ParseTime(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	if len(args) == 0 {
+		return ngin.Fail(errors.New("parse-time: no value"))
+	}
+	v := args[0].WithContext(ctx)
+	if len(args) == 1 {
+		t, err := ngin.ToTime(v)
+		if err != nil {
+			return ngin.Fail(fmt.Errorf("parse-time: %w", err))
+		}
+		return ngin.Time(t)
+	}
+	if err := ngin.Err(v.Value()); err != nil {
+		return ngin.Fail(err)
+	}
+	t, err := time.Parse(layout(args[1].WithContext(ctx)), v.String())
+	if err != nil {
+		return ngin.Fail(fmt.Errorf("parse-time: %w", err))
+	}
+	return ngin.Time(t)
+}
+
+func FormatTime(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	if len(args) == 0 {
+		return ngin.Fail(errors.New("format-time: no time"))
+	}
+	t, err := ngin.ToTime(args[0].WithContext(ctx))
+	if err != nil {
+		return ngin.Fail(fmt.Errorf("format-time: %w", err))
+	}
+	l := time.RFC3339
+	if len(args) > 1 {
+		l = layout(args[1].WithContext(ctx))
+	}
+	if l == http.TimeFormat {
+		t = t.UTC()
+	}
+	return ngin.String(t.Format(l))
+}
+
+func ParseDuration(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	if len(args) == 0 {
+		return ngin.Fail(errors.New("parse-duration: no value"))
+	}
+	d, err := ngin.ToDuration(args[0].WithContext(ctx))
+	if err != nil {
+		return ngin.Fail(fmt.Errorf("parse-duration: %w", err))
+	}
+	return ngin.Duration(d)
+}
+
+func Unix(ctx *ngin.Context, args ...ngin.Value) ngin.Value {
+	if len(args) == 0 {
+		return ngin.Int(time.Now().Unix())
+	}
+	t, err := ngin.ToTime(args[0].WithContext(ctx))
+	if err != nil {
+		return ngin.Fail(fmt.Errorf("unix: %w", err))
+	}
+	return ngin.Int(t.Unix())
+}
+
+func layout(v ngin.Value) string {
+	if l, ok := layouts[strings.ToLower(v.String())]; ok {
+		return l
+	}
+	return v.String()
+}
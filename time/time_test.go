@@ -0,0 +1,69 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package time_test
+
+import (
+	"testing"
+
+	"github.com/dev-mockingbird/ngin"
+	"github.com/dev-mockingbird/ngin/ngintest"
+	"github.com/dev-mockingbird/ngin/time"
+)
+
+func TestTime(t *testing.T) {
+	ctx, err := ngintest.Run(t, `
+issued = parse-time 2023-11-14T22:13:20Z;
+expires = issued + 1h30m;
+left = expires - parse-time 1700000000;
+http-date = format-time (expires + 30s) http;
+day = format-time issued date;
+stamp = unix expires;
+half = 90m / 2;
+ratio = 1h / 30m;
+label = "ttl " + 5m;
+expires > issued && left >= 90m && left == 5400 && now > expires {
+    matched = true;
+}
+`, time.Init, "issued", "expires", "left", "http-date", "day", "stamp", "half", "ratio", "label", "matched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expect := range map[string]string{
+		"expires":   "2023-11-14T23:43:20Z",
+		"left":      "1h30m",
+		"http-date": "Tue, 14 Nov 2023 23:43:50 GMT",
+		"day":       "2023-11-14",
+		"stamp":     "1700005400",
+		"half":      "45m",
+		"ratio":     "2",
+		"label":     "ttl 5m",
+	} {
+		if v := ctx.GetValue(name).String(); v != expect {
+			t.Fatalf("%s: expect %s, got %s", name, expect, v)
+		}
+	}
+	if !ctx.GetValue("matched").Bool() {
+		t.Fatal("expect the times to compare")
+	}
+}
+
+func TestTime_Fail(t *testing.T) {
+	for src, expect := range map[string]string{
+		`none = parse-time yesterday;`:                "parse-time: yesterday isn't a time at 1, 1",
+		`none = parse-time 2023 "2006-01";`:           `parse-time: parsing time "2023" as "2006-01": cannot parse "" as "-" at 1, 1`,
+		`none = parse-time 2023-11-14T22:13:20Z + 1;`: "can't compute 2023-11-14T22:13:20Z + 1 at 1, 1",
+		`none = 5m / 0;`:                              "division by zero at 1, 1",
+		`none = parse-duration "5 minutes";`:          "parse-duration: 5 minutes isn't a duration at 1, 1",
+	} {
+		ctx, err := ngintest.Run(t, src, time.Init, "none")
+		if err == nil || err.Error() != expect {
+			t.Fatalf("%s: expect %s, got %v", src, expect, err)
+		}
+		if _, ok := ctx.GetValue("none").(ngin.Null); !ok {
+			t.Fatalf("%s: a failed value shouldn't be assigned", src)
+		}
+	}
+}
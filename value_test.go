@@ -0,0 +1,150 @@
+// Copyright (c) 2023 Yang,Zhong
+//
+// This software is released under the MIT License.
+// https://opensource.org/licenses/MIT
+
+package ngin_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dev-mockingbird/ngin"
+)
+
+func TestValue_Convert(t *testing.T) {
+	cases := []struct {
+		value  ngin.Value
+		int    int64
+		float  float64
+		string string
+		bool   bool
+	}{
+		{ngin.Int(1234567890), 1234567890, 1234567890, "1234567890", true},
+		{ngin.Int(-42), -42, -42, "-42", true},
+		{ngin.Int(0), 0, 0, "0", false},
+		{ngin.Float(1.5), 1, 1.5, "1.5", true},
+		{ngin.Float(-0.25), 0, -0.25, "-0.25", true},
+		{ngin.Float(1234567890), 1234567890, 1234567890, "1234567890", true},
+		{ngin.Float(1e15), 1e15, 1e15, "1000000000000000", true},
+		{ngin.String("1234567890"), 1234567890, 1234567890, "1234567890", false},
+		{ngin.String("-7"), -7, -7, "-7", false},
+		{ngin.String("2.5"), 2, 2.5, "2.5", false},
+		{ngin.Bytes([]byte("1234567890")), 1234567890, 1234567890, "1234567890", false},
+		{ngin.Bytes([]byte("1e3")), 1000, 1000, "1e3", false},
+		{ngin.Bool(true), 1, 1, "true", true},
+		{ngin.Bool(false), 0, 0, "false", false},
+		{ngin.Null{}, 0, 0, "", false},
+		{ngin.String("abc"), 0, 0, "abc", false},
+		{ngin.Bytes([]byte("1.5.1")), 0, 0, "1.5.1", false},
+		{ngin.Slice{ngin.Int(2)}, 2, 2, "2", true},
+		{ngin.Slice{ngin.String("a"), ngin.String("b")}, 0, 0, "a, b", true},
+		{ngin.Slice{}, 0, 0, "", false},
+		{ngin.NewComplex(), 0, 0, "{}", false},
+		{ngin.Fail(errors.New("failed")), 0, 0, "", false},
+		{ngin.Duration(90 * time.Second), 90, 90, "1m30s", true},
+		{ngin.Duration(2 * time.Hour), 7200, 7200, "2h", true},
+		{ngin.Duration(1500 * time.Millisecond), 1, 1.5, "1.5s", true},
+		{ngin.Duration(0), 0, 0, "0s", false},
+		{ngin.Time(time.Unix(1700000000, 500000000).UTC()), 1700000000, 1700000000.5, "2023-11-14T22:13:20.5Z", true},
+		{ngin.Time(time.Time{}), time.Time{}.Unix(), float64(time.Time{}.Unix()), "0001-01-01T00:00:00Z", false},
+	}
+	for _, c := range cases {
+		if v := c.value.Int(); v != c.int {
+			t.Fatalf("%s: expect int %d, got %d", c.string, c.int, v)
+		}
+		if v := c.value.Float(); v != c.float {
+			t.Fatalf("%s: expect float %v, got %v", c.string, c.float, v)
+		}
+		if v := c.value.String(); v != c.string {
+			t.Fatalf("expect string %s, got %s", c.string, v)
+		}
+		if v := string(c.value.Bytes()); v != c.string {
+			t.Fatalf("expect bytes %s, got %s", c.string, v)
+		}
+		if v := c.value.Bool(); v != c.bool {
+			t.Fatalf("%s: expect bool %v, got %v", c.string, c.bool, v)
+		}
+	}
+}
+
+func TestValue_Compare(t *testing.T) {
+	complex := func(v string) ngin.Value {
+		c := ngin.NewComplex()
+		c.SetAttr("a", ngin.String(v))
+		return c
+	}
+	cases := []struct {
+		left, right ngin.Value
+		expect      int
+	}{
+		{ngin.Int(1), ngin.Int(1), 0},
+		{ngin.Int(-1), ngin.Int(1), -1},
+		{ngin.Float(1.5), ngin.Int(1), 1},
+		{ngin.Float(1), ngin.Int(1), 0},
+		{ngin.Float(-0.5), ngin.Float(0.5), -1},
+		{ngin.String("10"), ngin.Int(9), 1},
+		{ngin.String("10"), ngin.String("9"), 1},
+		{ngin.Bytes([]byte("9")), ngin.Float(9.5), -1},
+		{ngin.String("1.0"), ngin.Bytes([]byte("1")), 0},
+		{ngin.String("abc"), ngin.String("abd"), -1},
+		{ngin.String("abc"), ngin.Bytes([]byte("abc")), 0},
+		{ngin.String("abc"), ngin.Int(1), 1},
+		{ngin.String("nan"), ngin.Float(1), 1},
+		{ngin.Null{}, ngin.Null{}, 0},
+		{ngin.Null{}, ngin.String(""), -1},
+		{ngin.Null{}, ngin.Int(-1), -1},
+		{ngin.Bool(true), ngin.String("true"), 0},
+		{ngin.Bool(false), ngin.Int(1), -1},
+		{ngin.Bool(true), ngin.Int(0), 1},
+		{ngin.Slice{ngin.Int(1), ngin.String("2")}, ngin.Slice{ngin.String("1"), ngin.Int(2)}, 0},
+		{ngin.Slice{ngin.Int(1)}, ngin.Slice{ngin.Int(1), ngin.Int(2)}, -1},
+		{ngin.Slice{ngin.Int(2)}, ngin.Int(1), 1},
+		{ngin.Int(1), ngin.Slice{ngin.Int(1)}, 0},
+		{complex("x"), complex("x"), 0},
+		{complex("x"), complex("y"), -1},
+		{ngin.Duration(time.Minute), ngin.Duration(59 * time.Second), 1},
+		{ngin.Duration(time.Minute), ngin.Int(60), 0},
+		{ngin.Duration(1500 * time.Millisecond), ngin.String("1.5"), 0},
+		{ngin.Time(time.Unix(10, 1)), ngin.Time(time.Unix(10, 0)), 1},
+		{ngin.Time(time.Unix(10, 0)), ngin.Time(time.Unix(10, 0).UTC()), 0},
+		{ngin.Time(time.Unix(10, 0)), ngin.Int(11), -1},
+		{ngin.Null{}, ngin.Duration(0), -1},
+	}
+	for i, c := range cases {
+		if r := c.left.Compare(c.right); r != c.expect {
+			t.Fatalf("case %d: expect %d, got %d", i, c.expect, r)
+		}
+		if r := c.right.Compare(c.left); r != -c.expect {
+			t.Fatalf("case %d reversed: expect %d, got %d", i, -c.expect, r)
+		}
+	}
+}
+
+func TestToInt(t *testing.T) {
+	for _, c := range []struct {
+		value ngin.Value
+		int   int64
+		err   string
+	}{
+		{ngin.String("-12"), -12, ""},
+		{ngin.Bytes([]byte("2.9")), 2, ""},
+		{ngin.Float(3.5), 3, ""},
+		{ngin.String("abc"), 0, "abc isn't a number"},
+		{ngin.Null{}, 0, "null isn't a number"},
+		{ngin.Slice{ngin.Int(1), ngin.Int(2)}, 0, "1, 2 isn't a number"},
+		{ngin.Fail(errors.New("failed")), 0, "failed"},
+	} {
+		i, err := ngin.ToInt(c.value)
+		if c.err != "" {
+			if err == nil || err.Error() != c.err {
+				t.Fatalf("expect %s, got %v", c.err, err)
+			}
+			continue
+		}
+		if err != nil || i != c.int {
+			t.Fatalf("expect %d, got %d, %v", c.int, i, err)
+		}
+	}
+}